@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("command %d failed: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// checkpoint captures everything a command can change so a batch can be
+// rolled back as a whole.
+type checkpoint struct {
+	state    State
+	history  []MovementHistory
+	sequence int
+	done     []operation
+	undone   []operation
+}
+
+func (s *Service) checkpoint() checkpoint {
+	return checkpoint{
+		state:    s.storage.State.clone(),
+		history:  slices.Clone(s.storage.History),
+		sequence: s.storage.Sequence,
+		done:     slices.Clone(s.storage.Done),
+		undone:   slices.Clone(s.storage.Undone),
+	}
+}
+
+func (s *Service) restore(cp checkpoint) {
+	s.storage.State = cp.state
+	s.storage.History = cp.history
+	s.storage.Sequence = cp.sequence
+	s.storage.Done = cp.done
+	s.storage.Undone = cp.undone
+}
+
+// ExecuteBatch runs every command under a single lock. If any of them fails
+// the game is rolled back to where it was before the batch and a *BatchError
+// identifies the failing command.
+func (s *Service) ExecuteBatch(commands []CommandRequest) (State, error) {
+	if len(commands) == 0 {
+		return State{}, errors.New("no commands to execute")
+	}
+
+	s.storage.Mu.Lock()
+	defer s.storage.Mu.Unlock()
+	defer s.save()
+
+	cp := s.checkpoint()
+	events := make([]Event, 0, len(commands))
+	for i, cmd := range commands {
+		state, err := s.execute(cmd)
+		if err != nil {
+			s.restore(cp)
+			return State{}, &BatchError{Index: i, Err: err}
+		}
+		events = append(events, Event{Command: cmd, State: state})
+	}
+	s.settle()
+
+	for _, event := range events {
+		s.publish(event.Command, event.State)
+	}
+
+	return s.storage.State.clone(), nil
+}
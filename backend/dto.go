@@ -1,14 +1,151 @@
 package main
 
+import (
+	"errors"
+	"time"
+)
+
+// CommandRequest addresses one robot. Count says how many circles a pick or
+// drop moves, one if left out. X and Y are the destination of a move_to.
 type CommandRequest struct {
+	RobotID   string    `json:"robot_id,omitempty"`
 	Action    Action    `json:"action"`
 	Direction Direction `json:"direction,omitempty"`
+	Count     int       `json:"count,omitempty"`
+	X         *int      `json:"x,omitempty"`
+	Y         *int      `json:"y,omitempty"`
 }
 
+// StateResponse reports every robot in Robots. PositionX, PositionY and
+// Holding repeat the first robot for single-robot clients. TimeLimit and
+// RemainingMS are only set for timed games. Leaderboard is the layout
+// parameter that lists the game's leaderboard.
 type StateResponse struct {
-	PositionX int                          `json:"position_x"`
-	PositionY int                          `json:"position_y"`
-	Holding   *Circle                      `json:"holding,omitempty"`
-	Grid      [GridSize][GridSize][]Circle `json:"grid"`
-	Won       bool                         `json:"won"`
+	PositionX    int          `json:"position_x"`
+	PositionY    int          `json:"position_y"`
+	Holding      []Circle     `json:"holding,omitempty"`
+	Robots       []Robot      `json:"robots"`
+	Capacity     int          `json:"capacity"`
+	Movement     string       `json:"movement"`
+	Width        int          `json:"width"`
+	Height       int          `json:"height"`
+	Grid         [][][]Circle `json:"grid"`
+	Cells        [][]CellType `json:"cells,omitempty"`
+	StackLimits  [][]int      `json:"stack_limits,omitempty"`
+	Palette      Palette      `json:"palette"`
+	Costs        ActionCosts  `json:"costs"`
+	EnergyUsed   int          `json:"energy_used"`
+	EnergyBudget int          `json:"energy_budget,omitempty"`
+	Score        *Score       `json:"score,omitempty"`
+	StartedAt    time.Time    `json:"started_at"`
+	FinishedAt   *time.Time   `json:"finished_at,omitempty"`
+	ElapsedMS    int64        `json:"elapsed_ms"`
+	TimeLimit    int          `json:"time_limit,omitempty"`
+	RemainingMS  *int64       `json:"remaining_ms,omitempty"`
+	Lost         bool         `json:"lost"`
+	Won          bool         `json:"won"`
+	WinCondition string       `json:"win_condition"`
+	Goal         string       `json:"goal"`
+	LayoutID     string       `json:"layout_id,omitempty"`
+	Leaderboard  string       `json:"leaderboard"`
+}
+
+type SessionResponse struct {
+	ID    string        `json:"id"`
+	State StateResponse `json:"state"`
+}
+
+type ReplayResponse struct {
+	ReplayResult
+	State StateResponse `json:"state"`
+}
+
+type SolveResponse struct {
+	Commands []CommandRequest `json:"commands"`
+	Moves    int              `json:"moves"`
+}
+
+type EventResponse struct {
+	Command *CommandRequest `json:"command,omitempty"`
+	State   StateResponse   `json:"state"`
+}
+
+type HintResponse struct {
+	Command        CommandRequest `json:"command"`
+	MovesRemaining int            `json:"moves_remaining"`
+}
+
+type BatchErrorResponse struct {
+	Error       string `json:"error"`
+	FailedIndex int    `json:"failed_index"`
+	Reason      string `json:"reason"`
+}
+
+type ProgramRequest struct {
+	Source string `json:"source"`
+}
+
+type ProgramResponse struct {
+	Executed int            `json:"executed"`
+	State    *StateResponse `json:"state,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Line     int            `json:"line,omitempty"`
+}
+
+func newProgramResponse(result ProgramResult, err error, svc *Service) ProgramResponse {
+	resp := ProgramResponse{Executed: result.Executed}
+	if result.State.Grid != nil {
+		state := newStateResponse(result.State, svc)
+		resp.State = &state
+	}
+
+	var programErr *ProgramError
+	if errors.As(err, &programErr) {
+		resp.Error = programErr.Msg
+		resp.Line = programErr.Line
+	} else if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+func newStateResponse(state State, svc *Service) StateResponse {
+	win := svc.WinCondition()
+	robot := state.Robots[0]
+	clock := svc.Clock(time.Now())
+	resp := StateResponse{
+		PositionX:    robot.PositionX,
+		PositionY:    robot.PositionY,
+		Holding:      robot.Holding,
+		Robots:       state.Robots,
+		Capacity:     svc.Capacity(),
+		Movement:     string(svc.Movement()),
+		Width:        state.Width,
+		Height:       state.Height,
+		Grid:         state.Grid,
+		Cells:        state.Cells,
+		StackLimits:  state.stackLimitGrid(),
+		Palette:      svc.Palette(),
+		Costs:        svc.Costs(),
+		EnergyUsed:   state.EnergyUsed,
+		EnergyBudget: svc.EnergyBudget(),
+		Score:        svc.Score(state),
+		StartedAt:    clock.StartedAt,
+		ElapsedMS:    clock.Elapsed.Milliseconds(),
+		Lost:         clock.Lost,
+		Won:          win.Met(&state),
+		WinCondition: win.Name(),
+		Goal:         win.Description(),
+		LayoutID:     svc.LayoutID(),
+		Leaderboard:  svc.LeaderboardID(),
+	}
+	if !clock.FinishedAt.IsZero() {
+		resp.FinishedAt = &clock.FinishedAt
+	}
+	if clock.TimeLimit > 0 {
+		remaining := clock.Remaining.Milliseconds()
+		resp.TimeLimit = int(clock.TimeLimit / time.Second)
+		resp.RemainingMS = &remaining
+	}
+	return resp
 }
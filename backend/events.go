@@ -0,0 +1,46 @@
+package main
+
+const subscriberBuffer = 16
+
+type Event struct {
+	Command CommandRequest
+	State   State
+}
+
+// Subscribe registers a listener for state changes. Events are dropped for a
+// subscriber whose buffer is full rather than blocking the game. The returned
+// function unregisters the listener and closes the channel.
+func (s *Service) Subscribe() (<-chan Event, func()) {
+	s.storage.Mu.Lock()
+	defer s.storage.Mu.Unlock()
+
+	if s.subscribers == nil {
+		s.subscribers = map[int]chan Event{}
+	}
+
+	id := s.nextSubscriber
+	s.nextSubscriber++
+
+	events := make(chan Event, subscriberBuffer)
+	s.subscribers[id] = events
+
+	return events, func() {
+		s.storage.Mu.Lock()
+		defer s.storage.Mu.Unlock()
+
+		if _, ok := s.subscribers[id]; ok {
+			delete(s.subscribers, id)
+			close(events)
+		}
+	}
+}
+
+// publish notifies every subscriber. The caller must hold the storage lock.
+func (s *Service) publish(cmd CommandRequest, state State) {
+	for _, events := range s.subscribers {
+		select {
+		case events <- Event{Command: cmd, State: state.clone()}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+type Difficulty string
+
+const (
+	Easy   Difficulty = "easy"
+	Medium Difficulty = "medium"
+	Hard   Difficulty = "hard"
+)
+
+// The move limits of each difficulty on the default board. Larger boards
+// scale them with their width plus height, as travel grows with both.
+const (
+	maxEasyMoves   = 10
+	maxMediumMoves = 24
+)
+
+// The generator bounds each solve by nodes rather than time so that a seed
+// produces the same puzzle on any machine. generatorTimeout caps the whole
+// search so a large board cannot hold up the request; running out of time
+// fails the generation rather than skipping an attempt, which keeps the
+// result of a seed the same however fast the machine is.
+var (
+	generatorAttempts = 60
+	generatorLimits   = SolverLimits{MaxNodes: 100_000}
+	generatorTimeout  = 10 * time.Second
+)
+
+type GeneratorOptions struct {
+	Width      int
+	Height     int
+	Robots     int
+	Colours    []Circle
+	Difficulty Difficulty
+	Seed       uint64
+}
+
+// Grade maps the length of an optimal solution on a width x height board to
+// a difficulty.
+func Grade(moves, width, height int) Difficulty {
+	span := width + height
+	switch {
+	case moves <= scaleMoves(maxEasyMoves, span):
+		return Easy
+	case moves <= scaleMoves(maxMediumMoves, span):
+		return Medium
+	}
+	return Hard
+}
+
+// scaleMoves stretches a move limit for the default board to a board whose
+// width plus height is span, never below the default.
+func scaleMoves(moves, span int) int {
+	return max(moves, moves*span/(2*DefaultGridSize))
+}
+
+func ParseDifficulty(name string) (Difficulty, error) {
+	switch d := Difficulty(name); d {
+	case Easy, Medium, Hard:
+		return d, nil
+	}
+	return "", fmt.Errorf("unknown difficulty %q", name)
+}
+
+// circleCount picks how many circles to place for a difficulty; more circles
+// make longer solutions more likely. The count is capped so that large boards
+// get longer routes rather than more circles, which the solver could not
+// search in time.
+func circleCount(difficulty Difficulty, cells int) int {
+	switch difficulty {
+	case Easy:
+		return max(2, min(cells/3, 3))
+	case Medium:
+		return max(3, min(cells*2/3, 4))
+	}
+	return max(4, min(cells*3/4, 5))
+}
+
+// Generate builds a random layout that this game's rules and win condition
+// can solve and whose optimal solution grades as the requested difficulty.
+// The same options always produce the same layout.
+func (s *Service) Generate(opts GeneratorOptions) (*Layout, int, error) {
+	if opts.Width < 1 || opts.Height < 1 || opts.Width > MaxGridSize || opts.Height > MaxGridSize {
+		return nil, 0, fmt.Errorf("invalid grid size %dx%d", opts.Width, opts.Height)
+	}
+	if opts.Robots == 0 {
+		opts.Robots = 1
+	}
+	if opts.Robots < 0 || opts.Robots > opts.Width*opts.Height {
+		return nil, 0, fmt.Errorf("cannot fit %d robots on a %dx%d grid", opts.Robots, opts.Width, opts.Height)
+	}
+	if len(opts.Colours) == 0 {
+		return nil, 0, fmt.Errorf("no colours to generate with")
+	}
+
+	s.storage.Mu.Lock()
+	winConfig, stackLimit := s.config.Win, s.config.StackLimit
+	palette := s.palette
+	s.storage.Mu.Unlock()
+
+	for _, colour := range opts.Colours {
+		if !palette.has(colour) {
+			return nil, 0, fmt.Errorf("unknown circle %q", colour)
+		}
+	}
+
+	win, err := NewWinCondition(winConfig, opts.Width, opts.Height)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rng := rand.New(rand.NewPCG(opts.Seed, uint64(len(opts.Difficulty))))
+	count := circleCount(opts.Difficulty, opts.Width*opts.Height)
+
+	deadline := time.Now().Add(generatorTimeout)
+	for range generatorAttempts {
+		layout := s.randomLayout(rng, opts, count, stackLimit)
+		state := layout.State()
+		if win.Met(&state) {
+			continue
+		}
+
+		limits := generatorLimits
+		limits.Timeout = time.Until(deadline)
+		solution, err := s.solve(state, win, limits)
+		if errors.Is(err, ErrSolverLimit) && !time.Now().Before(deadline) {
+			return nil, 0, fmt.Errorf("gave up generating a %s %dx%d puzzle after %v",
+				opts.Difficulty, opts.Width, opts.Height, generatorTimeout)
+		}
+		if err != nil || Grade(len(solution), opts.Width, opts.Height) != opts.Difficulty {
+			continue
+		}
+
+		layout.ID = fmt.Sprintf("generated-%s-%d", opts.Difficulty, opts.Seed)
+		return layout, len(solution), nil
+	}
+
+	return nil, 0, fmt.Errorf("could not generate a solvable %s %dx%d puzzle with seed %d",
+		opts.Difficulty, opts.Width, opts.Height, opts.Seed)
+}
+
+// randomLayout drops count random circles on random cells, only where the
+// stacking rules and the game's stack limit allow them, and puts the robots
+// on distinct random cells. The layout carries the stack limit, so it is
+// solved and graded as it will be played.
+func (s *Service) randomLayout(rng *rand.Rand, opts GeneratorOptions, count, stackLimit int) *Layout {
+	grid := make([][][]Circle, opts.Width)
+	for x := range grid {
+		grid[x] = make([][]Circle, opts.Height)
+		for y := range grid[x] {
+			grid[x][y] = []Circle{}
+		}
+	}
+
+	for range count {
+		circle := opts.Colours[rng.IntN(len(opts.Colours))]
+		for range opts.Width * opts.Height {
+			x, y := rng.IntN(opts.Width), rng.IntN(opts.Height)
+			full := stackLimit > 0 && len(grid[x][y]) >= stackLimit
+			if !full && violatedRule(s.rules, grid[x][y], circle) == nil {
+				grid[x][y] = append(grid[x][y], circle)
+				break
+			}
+		}
+	}
+
+	layout := &Layout{
+		Width:      opts.Width,
+		Height:     opts.Height,
+		Grid:       grid,
+		StackLimit: stackLimit,
+	}
+	for i, cell := range rng.Perm(opts.Width * opts.Height)[:opts.Robots] {
+		layout.Robots = append(layout.Robots, Robot{
+			ID:        robotID(i),
+			PositionX: cell % opts.Width,
+			PositionY: cell / opts.Width,
+		})
+	}
+	return layout
+}
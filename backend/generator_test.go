@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestService_Generate(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        GeneratorOptions
+		expectError bool
+	}{
+		{
+			name: "easy",
+			opts: GeneratorOptions{Width: 3, Height: 3, Colours: []Circle{Red, Green, Blue}, Difficulty: Easy, Seed: 1},
+		},
+		{
+			name: "medium",
+			opts: GeneratorOptions{Width: 3, Height: 3, Colours: []Circle{Red, Green, Blue}, Difficulty: Medium, Seed: 2},
+		},
+		{
+			name: "hard",
+			opts: GeneratorOptions{Width: 3, Height: 3, Colours: []Circle{Red, Green, Blue}, Difficulty: Hard, Seed: 2},
+		},
+		{
+			name: "restricted colours",
+			opts: GeneratorOptions{Width: 4, Height: 2, Colours: []Circle{Red}, Difficulty: Easy, Seed: 4},
+		},
+		{
+			name:        "unknown colour",
+			opts:        GeneratorOptions{Width: 3, Height: 3, Colours: []Circle{"purple"}, Difficulty: Easy},
+			expectError: true,
+		},
+		{
+			name:        "invalid size",
+			opts:        GeneratorOptions{Width: 0, Height: 3, Colours: []Circle{Red}, Difficulty: Easy},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := NewService(NewDataStore(DefaultGridSize, DefaultGridSize), ClassicRule{}, LastColumnCondition{})
+			svc.config = testDefaults()
+
+			layout, moves, err := svc.Generate(tt.opts)
+
+			if tt.expectError {
+				if err == nil {
+					t.Fatalf("expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if grade := Grade(moves, tt.opts.Width, tt.opts.Height); grade != tt.opts.Difficulty {
+				t.Fatalf("expected %s puzzle, got %s (%d moves)", tt.opts.Difficulty, grade, moves)
+			}
+
+			if err := layout.Validate(svc.rules, svc.palette, svc.capacity); err != nil {
+				t.Fatalf("generated layout is invalid: %v", err)
+			}
+
+			if _, err := svc.Reset(layout); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			solution, err := svc.Solve(DefaultSolverLimits)
+			if err != nil {
+				t.Fatalf("generated layout is not solvable: %v", err)
+			}
+			if len(solution) != moves {
+				t.Fatalf("expected optimal solution of %d moves, got %d", moves, len(solution))
+			}
+
+			again, _, err := svc.Generate(tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(layout, again) {
+				t.Fatalf("expected seed %d to reproduce the same layout", tt.opts.Seed)
+			}
+		})
+	}
+}
+
+func TestService_GenerateWithStackLimit(t *testing.T) {
+	for _, seed := range []uint64{13, 18} {
+		svc := NewService(NewDataStore(DefaultGridSize, DefaultGridSize), ClassicRule{}, LastColumnCondition{})
+		svc.config = testDefaults()
+		svc.config.StackLimit = 2
+
+		opts := GeneratorOptions{Width: 2, Height: 3, Colours: []Circle{Red, Green, Blue}, Difficulty: Medium, Seed: seed}
+		layout, moves, err := svc.Generate(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := svc.Reset(layout); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		solution, err := svc.Solve(DefaultSolverLimits)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(solution) != moves {
+			t.Fatalf("seed %d: generated with %d moves but plays in %d", seed, moves, len(solution))
+		}
+	}
+}
+
+func TestGrade(t *testing.T) {
+	tests := []struct {
+		moves         int
+		width, height int
+		expected      Difficulty
+	}{
+		{moves: 1, width: 3, height: 3, expected: Easy},
+		{moves: maxEasyMoves, width: 3, height: 3, expected: Easy},
+		{moves: maxEasyMoves + 1, width: 3, height: 3, expected: Medium},
+		{moves: maxMediumMoves, width: 3, height: 3, expected: Medium},
+		{moves: maxMediumMoves + 1, width: 3, height: 3, expected: Hard},
+		{moves: maxEasyMoves + 1, width: 2, height: 2, expected: Medium},
+		{moves: maxEasyMoves * 2, width: 6, height: 6, expected: Easy},
+		{moves: maxEasyMoves*2 + 1, width: 6, height: 6, expected: Medium},
+		{moves: maxMediumMoves*2 + 1, width: 6, height: 6, expected: Hard},
+	}
+
+	for _, tt := range tests {
+		if grade := Grade(tt.moves, tt.width, tt.height); grade != tt.expected {
+			t.Errorf("Grade(%d, %d, %d) = %s, expected %s", tt.moves, tt.width, tt.height, grade, tt.expected)
+		}
+	}
+}
@@ -2,74 +2,381 @@ package main
 
 import (
 	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"mime/multipart"
 	"net/http"
-	"time"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+const serviceKey = "service"
+
 type Handler struct {
-	Service *Service
+	Sessions *SessionManager
 }
 
-func NewHandler(s *Service) *Handler {
-	return &Handler{Service: s}
+func NewHandler(sessions *SessionManager) *Handler {
+	return &Handler{Sessions: sessions}
 }
 
-func (h *Handler) GetState(c *gin.Context) {
-	state := h.Service.GetState()
-	resp := StateResponse{
-		PositionX: state.Robot.PositionX,
-		PositionY: state.Robot.PositionY,
-		Holding:   state.Robot.Holding,
-		Grid:      state.Grid,
-		Won:       h.Service.HasWon(),
+func (h *Handler) CreateSession(c *gin.Context) {
+	var cfg GameConfig
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&cfg); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+	}
+
+	session, err := h.Sessions.Create(cfg)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	c.JSON(http.StatusOK, resp)
+
+	state := session.Service.GetState()
+	c.JSON(http.StatusCreated, SessionResponse{
+		ID:    session.ID,
+		State: newStateResponse(state, session.Service),
+	})
+}
+
+// LoadSession resolves the :id path parameter and makes the session's
+// service available to the handlers that follow.
+func (h *Handler) LoadSession(c *gin.Context) {
+	session, ok := h.Sessions.Get(c.Param("id"))
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	c.Set(serviceKey, session.Service)
+	c.Next()
+}
+
+func sessionService(c *gin.Context) *Service {
+	return c.MustGet(serviceKey).(*Service)
+}
+
+func (h *Handler) GetState(c *gin.Context) {
+	svc := sessionService(c)
+	state := svc.GetState()
+	c.JSON(http.StatusOK, newStateResponse(state, svc))
+}
+
+// Events streams the session as Server-Sent Events: the current state first,
+// then one "state" event after every change.
+func (h *Handler) Events(c *gin.Context) {
+	svc := sessionService(c)
+
+	events, cancel := svc.Subscribe()
+	defer cancel()
+
+	c.SSEvent("state", EventResponse{State: newStateResponse(svc.GetState(), svc)})
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent("state", EventResponse{
+				Command: &event.Command,
+				State:   newStateResponse(event.State, svc),
+			})
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
 }
 
 func (h *Handler) ProcessCommand(c *gin.Context) {
+	svc := sessionService(c)
+
 	var req CommandRequest
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
 
-	var (
-		state State
-		err   error
-	)
-
 	switch req.Action {
 	case Move:
 		if req.Direction == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "missing direction for move action"})
 			return
 		}
-		state, err = h.Service.Move(req.Direction)
-	case PickUp:
-		state, err = h.Service.Pick()
-	case Drop:
-		state, err = h.Service.Drop()
+	case MoveTo:
+		if req.X == nil || req.Y == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing x or y for move_to action"})
+			return
+		}
+	case PickUp, Drop:
 	default:
 		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown action"})
 		return
 	}
 
+	state, err := svc.Execute(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, newStateResponse(state, svc))
+}
+
+func (h *Handler) ProcessCommands(c *gin.Context) {
+	svc := sessionService(c)
+
+	var commands []CommandRequest
+	if err := c.BindJSON(&commands); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	state, err := svc.ExecuteBatch(commands)
+
+	var batchErr *BatchError
+	switch {
+	case errors.As(err, &batchErr):
+		c.JSON(http.StatusBadRequest, BatchErrorResponse{
+			Error:       err.Error(),
+			FailedIndex: batchErr.Index,
+			Reason:      batchErr.Err.Error(),
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, newStateResponse(state, svc))
+}
+
+// RunProgram accepts the program source either as a plain text body or as
+// the "source" field of a JSON object.
+func (h *Handler) RunProgram(c *gin.Context) {
+	svc := sessionService(c)
+
+	var req ProgramRequest
+	if c.ContentType() == "application/json" {
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+	} else {
+		source, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		req.Source = string(source)
+	}
+
+	program, err := ParseProgram(req.Source)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, newProgramResponse(ProgramResult{}, err, svc))
+		return
+	}
+
+	result, err := svc.RunProgram(program)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, newProgramResponse(result, err, svc))
+		return
+	}
+
+	c.JSON(http.StatusOK, newProgramResponse(result, nil, svc))
+}
+
+// Reset restarts the session's game. An optional JSON layout in the body
+// replaces the starting board.
+func (h *Handler) Reset(c *gin.Context) {
+	svc := sessionService(c)
+
+	var layout *Layout
+	if difficulty := c.Query("generate"); difficulty != "" {
+		generated, err := generateLayout(c, svc, difficulty)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		layout = generated
+	} else if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(&layout); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+	}
+
+	state, err := svc.Reset(layout)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, newStateResponse(state, svc))
+}
+
+// generateLayout builds a puzzle for the current board size from the
+// seed and colours query parameters. Without a seed a random one is picked;
+// it is echoed back in the layout ID so the puzzle can be replayed.
+func generateLayout(c *gin.Context, svc *Service, difficulty string) (*Layout, error) {
+	level, err := ParseDifficulty(difficulty)
+	if err != nil {
+		return nil, err
+	}
+
+	seed := rand.Uint64()
+	if raw := c.Query("seed"); raw != "" {
+		if seed, err = strconv.ParseUint(raw, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid seed %q", raw)
+		}
+	}
+
+	colours := svc.Palette().Names()
+	if raw := c.Query("colours"); raw != "" {
+		colours = nil
+		for name := range strings.SplitSeq(raw, ",") {
+			colours = append(colours, Circle(strings.TrimSpace(name)))
+		}
+	}
+
+	state := svc.GetState()
+	layout, _, err := svc.Generate(GeneratorOptions{
+		Width:      state.Width,
+		Height:     state.Height,
+		Robots:     len(state.Robots),
+		Colours:    colours,
+		Difficulty: level,
+		Seed:       seed,
+	})
+	return layout, err
+}
+
+func (h *Handler) Undo(c *gin.Context) {
+	svc := sessionService(c)
+
+	state, err := svc.Undo()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	resp := StateResponse{
-		PositionX: state.Robot.PositionX,
-		PositionY: state.Robot.PositionY,
-		Holding:   state.Robot.Holding,
-		Grid:      state.Grid,
-		Won:       h.Service.HasWon(),
+	c.JSON(http.StatusOK, newStateResponse(state, svc))
+}
+
+func (h *Handler) Redo(c *gin.Context) {
+	svc := sessionService(c)
+
+	state, err := svc.Redo()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, resp)
+	c.JSON(http.StatusOK, newStateResponse(state, svc))
+}
+
+// Replay accepts either the JSON from GET /history or the CSV from GET /export,
+// as a raw body or as a "history" multipart file.
+func (h *Handler) Replay(c *gin.Context) {
+	svc := sessionService(c)
+
+	var (
+		history []MovementHistory
+		err     error
+	)
+
+	switch c.ContentType() {
+	case "application/json":
+		err = c.ShouldBindJSON(&history)
+	case "multipart/form-data":
+		var file multipart.File
+		if file, _, err = c.Request.FormFile("history"); err == nil {
+			defer file.Close()
+			history, err = ParseHistoryCSV(file)
+		}
+	default:
+		history, err = ParseHistoryCSV(c.Request.Body)
+	}
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid history: %v", err)})
+		return
+	}
+
+	result, state := svc.Replay(history)
+
+	status := http.StatusOK
+	if result.Diverged {
+		status = http.StatusConflict
+	}
+
+	c.JSON(status, ReplayResponse{
+		ReplayResult: result,
+		State:        newStateResponse(state, svc),
+	})
+}
+
+func (h *Handler) Solve(c *gin.Context) {
+	commands, err := sessionService(c).Solve(DefaultSolverLimits)
+	switch {
+	case errors.Is(err, ErrSolverLimit):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SolveResponse{Commands: commands, Moves: len(commands)})
+}
+
+func (h *Handler) Hint(c *gin.Context) {
+	command, remaining, err := sessionService(c).Hint(DefaultSolverLimits)
+	switch {
+	case errors.Is(err, ErrSolverLimit):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, HintResponse{Command: command, MovesRemaining: remaining})
+}
+
+func (h *Handler) GetHistory(c *gin.Context) {
+	c.JSON(http.StatusOK, sessionService(c).GetHistory())
+}
+
+// GetLeaderboard ranks the won games of the layout given by the layout query
+// parameter, as reported in each game's state, or of every layout without
+// one.
+func (h *Handler) GetLeaderboard(c *gin.Context) {
+	entries, err := h.Sessions.Leaderboard(c.Query("layout"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// GetArchive lists the games played in this session before each reset.
+func (h *Handler) GetArchive(c *gin.Context) {
+	games, err := sessionService(c).Archive()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, games)
 }
 
 func (h *Handler) ExportHistory(c *gin.Context) {
@@ -79,13 +386,13 @@ func (h *Handler) ExportHistory(c *gin.Context) {
 	writer := csv.NewWriter(c.Writer)
 	defer writer.Flush()
 
-	if err := writer.Write([]string{"Timestamp", "Moves"}); err != nil {
+	if err := writer.Write(historyHeader); err != nil {
 		c.Status(http.StatusInternalServerError)
 		return
 	}
 
-	for _, record := range h.Service.GetHistory() {
-		if err := writer.Write([]string{record.Timestamp.Format(time.RFC3339), record.Moves}); err != nil {
+	for _, record := range sessionService(c).GetHistory() {
+		if err := writer.Write(historyRow(record)); err != nil {
 			c.Status(http.StatusInternalServerError)
 			return
 		}
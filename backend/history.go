@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+var historyHeader = []string{
+	"Sequence", "Timestamp", "Robot", "Action", "Direction", "Circle", "Count",
+	"FromX", "FromY", "ToX", "ToY", "Success", "Error", "Energy", "Score", "Moves",
+}
+
+// optionalHistoryColumns may be missing from files exported before they were
+// added.
+var optionalHistoryColumns = map[string]bool{"Robot": true, "Count": true, "Energy": true, "Score": true}
+
+func historyRow(record MovementHistory) []string {
+	return []string{
+		strconv.Itoa(record.Sequence),
+		record.Timestamp.Format(time.RFC3339Nano),
+		record.RobotID,
+		string(record.Action),
+		string(record.Direction),
+		string(record.Circle),
+		strconv.Itoa(record.Count),
+		strconv.Itoa(record.FromX),
+		strconv.Itoa(record.FromY),
+		strconv.Itoa(record.ToX),
+		strconv.Itoa(record.ToY),
+		strconv.FormatBool(record.Success),
+		record.Error,
+		strconv.Itoa(record.Energy),
+		strconv.Itoa(record.Score),
+		record.Description(),
+	}
+}
+
+// ParseHistoryCSV reads a file produced by the export endpoint. Columns are
+// matched by header name, so their order does not matter.
+func ParseHistoryCSV(r io.Reader) ([]MovementHistory, error) {
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading history header: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range historyHeader {
+		if _, ok := columns[name]; !ok && !optionalHistoryColumns[name] {
+			return nil, fmt.Errorf("history is missing the %s column", name)
+		}
+	}
+
+	var history []MovementHistory
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return history, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		record, err := parseHistoryRow(row, columns)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		history = append(history, record)
+	}
+}
+
+func parseHistoryRow(row []string, columns map[string]int) (MovementHistory, error) {
+	field := func(name string) string {
+		i, ok := columns[name]
+		if !ok {
+			return ""
+		}
+		return row[i]
+	}
+
+	var (
+		record MovementHistory
+		err    error
+	)
+
+	ints := map[string]*int{
+		"Sequence": &record.Sequence,
+		"FromX":    &record.FromX,
+		"FromY":    &record.FromY,
+		"ToX":      &record.ToX,
+		"ToY":      &record.ToY,
+	}
+	for name, dest := range ints {
+		if *dest, err = strconv.Atoi(field(name)); err != nil {
+			return MovementHistory{}, fmt.Errorf("invalid %s %q", name, field(name))
+		}
+	}
+
+	if record.Timestamp, err = time.Parse(time.RFC3339Nano, field("Timestamp")); err != nil {
+		return MovementHistory{}, fmt.Errorf("invalid Timestamp %q", field("Timestamp"))
+	}
+	if record.Success, err = strconv.ParseBool(field("Success")); err != nil {
+		return MovementHistory{}, fmt.Errorf("invalid Success %q", field("Success"))
+	}
+
+	optional := map[string]*int{
+		"Count":  &record.Count,
+		"Energy": &record.Energy,
+		"Score":  &record.Score,
+	}
+	for name, dest := range optional {
+		if value := field(name); value != "" {
+			if *dest, err = strconv.Atoi(value); err != nil {
+				return MovementHistory{}, fmt.Errorf("invalid %s %q", name, value)
+			}
+		}
+	}
+
+	record.RobotID = field("Robot")
+	record.Action = Action(field("Action"))
+	record.Direction = Direction(field("Direction"))
+	record.Circle = Circle(field("Circle"))
+	record.Error = field("Error")
+
+	return record, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Layout describes a starting board. Grid is indexed [x][y] like State.Grid,
+// with each stack listed bottom to top. A board with a single robot can place
+// it with RobotX, RobotY and Holding instead of listing Robots. Cells, also
+// indexed [x][y], marks walls and zones; leaving it out makes every cell floor.
+// StackLimits caps the stack on single cells and StackLimit on the rest.
+type Layout struct {
+	ID          string       `json:"id,omitempty"`
+	Width       int          `json:"width"`
+	Height      int          `json:"height"`
+	Grid        [][][]Circle `json:"grid"`
+	RobotX      int          `json:"robot_x"`
+	RobotY      int          `json:"robot_y"`
+	Holding     []Circle     `json:"holding,omitempty"`
+	Robots      []Robot      `json:"robots,omitempty"`
+	Cells       [][]CellType `json:"cells,omitempty"`
+	StackLimit  int          `json:"stack_limit,omitempty"`
+	StackLimits [][]int      `json:"stack_limits,omitempty"`
+}
+
+func LoadLayout(path string) (*Layout, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var layout Layout
+	if err := json.Unmarshal(data, &layout); err != nil {
+		return nil, fmt.Errorf("parsing layout %s: %w", path, err)
+	}
+	return &layout, nil
+}
+
+// Validate checks the layout fits its declared size and that every stack,
+// carried ones included, holds circles from the palette and could have been
+// built under the given stacking rules by robots carrying at most capacity
+// circles.
+func (l *Layout) Validate(rules StackingRule, palette Palette, capacity int) error {
+	if l.Width < 1 || l.Height < 1 || l.Width > MaxGridSize || l.Height > MaxGridSize {
+		return fmt.Errorf("invalid grid size %dx%d", l.Width, l.Height)
+	}
+
+	if len(l.Grid) != l.Width {
+		return fmt.Errorf("layout grid has %d columns, expected %d", len(l.Grid), l.Width)
+	}
+
+	for x := range l.Grid {
+		if len(l.Grid[x]) != l.Height {
+			return fmt.Errorf("layout column %d has %d cells, expected %d", x, len(l.Grid[x]), l.Height)
+		}
+
+		for y, stack := range l.Grid[x] {
+			if err := validateStack(rules, palette, stack); err != nil {
+				return fmt.Errorf("cell (%d,%d): %w", x, y, err)
+			}
+		}
+	}
+
+	if err := validateRobots(l.robots(), l.Width, l.Height); err != nil {
+		return err
+	}
+
+	for _, robot := range l.robots() {
+		if len(robot.Holding) > capacity {
+			return fmt.Errorf("robot %s holds %d circles but can only carry %d", robot.ID, len(robot.Holding), capacity)
+		}
+		if err := validateStack(rules, palette, robot.Holding); err != nil {
+			return fmt.Errorf("robot %s: %w", robot.ID, err)
+		}
+	}
+
+	if err := l.validateCells(); err != nil {
+		return err
+	}
+	return l.validateStackLimits()
+}
+
+// validateStackLimits checks the limits match the grid and that no starting
+// stack is already over its limit.
+func (l *Layout) validateStackLimits() error {
+	if l.StackLimit < 0 {
+		return fmt.Errorf("invalid stack limit %d", l.StackLimit)
+	}
+
+	if l.StackLimits != nil {
+		if len(l.StackLimits) != l.Width {
+			return fmt.Errorf("layout stack limits have %d columns, expected %d", len(l.StackLimits), l.Width)
+		}
+		for x := range l.StackLimits {
+			if len(l.StackLimits[x]) != l.Height {
+				return fmt.Errorf("layout stack limits column %d has %d cells, expected %d",
+					x, len(l.StackLimits[x]), l.Height)
+			}
+			for y, limit := range l.StackLimits[x] {
+				if limit < 0 {
+					return fmt.Errorf("cell (%d,%d): invalid stack limit %d", x, y, limit)
+				}
+			}
+		}
+	}
+
+	state := l.State()
+	return state.overfull()
+}
+
+// validateCells checks the cell types match the grid and that walls are left
+// empty. Robots must already be known to be inside the grid.
+func (l *Layout) validateCells() error {
+	if l.Cells == nil {
+		return nil
+	}
+	if len(l.Cells) != l.Width {
+		return fmt.Errorf("layout cells have %d columns, expected %d", len(l.Cells), l.Width)
+	}
+
+	for x := range l.Cells {
+		if len(l.Cells[x]) != l.Height {
+			return fmt.Errorf("layout cells column %d has %d cells, expected %d", x, len(l.Cells[x]), l.Height)
+		}
+		for y, cell := range l.Cells[x] {
+			if !knownCellType(cell) {
+				return fmt.Errorf("cell (%d,%d): unknown cell type %q", x, y, cell)
+			}
+			if cell == Wall && len(l.Grid[x][y]) > 0 {
+				return fmt.Errorf("cell (%d,%d): a wall cannot hold circles", x, y)
+			}
+		}
+	}
+
+	for _, robot := range l.robots() {
+		if l.Cells[robot.PositionX][robot.PositionY] == Wall {
+			return fmt.Errorf("robot %s starts inside a wall at (%d,%d)", robot.ID, robot.PositionX, robot.PositionY)
+		}
+	}
+	return nil
+}
+
+func validateStack(rules StackingRule, palette Palette, stack []Circle) error {
+	for i, circle := range stack {
+		if !palette.has(circle) {
+			return fmt.Errorf("unknown circle %q", circle)
+		}
+		rejected := violatedRule(rules, stack[:i], circle)
+		switch {
+		case rejected != nil && i == 0:
+			return fmt.Errorf("%s cannot start a stack (rejected by %s)", circle, rejected.Name())
+		case rejected != nil:
+			return fmt.Errorf("%s cannot be stacked on %s (rejected by %s)", circle, stack[i-1], rejected.Name())
+		}
+	}
+	return nil
+}
+
+// validateRobots checks every robot has its own ID and its own cell inside
+// the grid.
+func validateRobots(robots []Robot, width, height int) error {
+	if len(robots) == 0 {
+		return errors.New("there are no robots on the grid")
+	}
+
+	ids := map[string]bool{}
+	cells := map[[2]int]string{}
+	for _, robot := range robots {
+		if robot.ID == "" {
+			return errors.New("every robot needs an ID")
+		}
+		if ids[robot.ID] {
+			return fmt.Errorf("robot ID %q is used twice", robot.ID)
+		}
+		ids[robot.ID] = true
+
+		if robot.PositionX < 0 || robot.PositionX >= width || robot.PositionY < 0 || robot.PositionY >= height {
+			return fmt.Errorf("robot %s position (%d,%d) is outside the %dx%d grid",
+				robot.ID, robot.PositionX, robot.PositionY, width, height)
+		}
+
+		cell := [2]int{robot.PositionX, robot.PositionY}
+		if other, ok := cells[cell]; ok {
+			return fmt.Errorf("robots %s and %s share cell (%d,%d)", other, robot.ID, robot.PositionX, robot.PositionY)
+		}
+		cells[cell] = robot.ID
+	}
+	return nil
+}
+
+func (l *Layout) robots() []Robot {
+	if len(l.Robots) > 0 {
+		return l.Robots
+	}
+	return []Robot{{
+		ID:        robotID(0),
+		PositionX: l.RobotX,
+		PositionY: l.RobotY,
+		Holding:   l.Holding,
+	}}
+}
+
+func (l *Layout) State() State {
+	state := State{
+		Robots:      l.robots(),
+		Width:       l.Width,
+		Height:      l.Height,
+		Grid:        l.Grid,
+		Cells:       l.Cells,
+		StackLimit:  l.StackLimit,
+		StackLimits: l.StackLimits,
+	}
+	return state.clone()
+}
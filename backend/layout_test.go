@@ -0,0 +1,317 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLayout_Validate(t *testing.T) {
+	red := Red
+	purple := Circle("purple")
+
+	tests := []struct {
+		name         string
+		layout       Layout
+		errorMessage string
+	}{
+		{
+			name: "valid layout",
+			layout: Layout{
+				Width: 2, Height: 1,
+				Grid:    [][][]Circle{{{Green, Blue, Red}}, {{}}},
+				Holding: []Circle{red},
+			},
+		},
+		{
+			name: "stack breaks the rules",
+			layout: Layout{
+				Width: 2, Height: 1,
+				Grid: [][][]Circle{{{Green}}, {{Red, Blue}}},
+			},
+			errorMessage: "cell (1,0): blue cannot be stacked on red (rejected by classic)",
+		},
+		{
+			name: "grid smaller than declared",
+			layout: Layout{
+				Width: 3, Height: 1,
+				Grid: [][][]Circle{{{Green}}, {{}}},
+			},
+			errorMessage: "layout grid has 2 columns, expected 3",
+		},
+		{
+			name: "robot outside the grid",
+			layout: Layout{
+				Width: 1, Height: 1,
+				Grid:   [][][]Circle{{{}}},
+				RobotY: 1,
+			},
+			errorMessage: "robot 1 position (0,1) is outside the 1x1 grid",
+		},
+		{
+			name: "several robots",
+			layout: Layout{
+				Width: 2, Height: 1,
+				Grid:   [][][]Circle{{{}}, {{}}},
+				Robots: []Robot{{ID: "A"}, {ID: "B", PositionX: 1, Holding: []Circle{red}}},
+			},
+		},
+		{
+			name: "robots sharing a cell",
+			layout: Layout{
+				Width: 2, Height: 1,
+				Grid:   [][][]Circle{{{}}, {{}}},
+				Robots: []Robot{{ID: "A", PositionX: 1}, {ID: "B", PositionX: 1}},
+			},
+			errorMessage: "robots A and B share cell (1,0)",
+		},
+		{
+			name: "duplicate robot IDs",
+			layout: Layout{
+				Width: 2, Height: 1,
+				Grid:   [][][]Circle{{{}}, {{}}},
+				Robots: []Robot{{ID: "A"}, {ID: "A", PositionX: 1}},
+			},
+			errorMessage: `robot ID "A" is used twice`,
+		},
+		{
+			name: "cells with walls and zones",
+			layout: Layout{
+				Width: 2, Height: 2,
+				Grid:  [][][]Circle{{{Red}, {}}, {{}, {Green}}},
+				Cells: [][]CellType{{NoDrop, Wall}, {Floor, DropOnly}},
+			},
+		},
+		{
+			name: "wall holding circles",
+			layout: Layout{
+				Width: 2, Height: 1,
+				Grid:  [][][]Circle{{{}}, {{Red}}},
+				Cells: [][]CellType{{Floor}, {Wall}},
+			},
+			errorMessage: "cell (1,0): a wall cannot hold circles",
+		},
+		{
+			name: "robot inside a wall",
+			layout: Layout{
+				Width: 2, Height: 1,
+				Grid:  [][][]Circle{{{}}, {{}}},
+				Cells: [][]CellType{{Wall}, {Floor}},
+			},
+			errorMessage: "robot 1 starts inside a wall at (0,0)",
+		},
+		{
+			name: "unknown cell type",
+			layout: Layout{
+				Width: 1, Height: 1,
+				Grid:  [][][]Circle{{{}}},
+				Cells: [][]CellType{{"lava"}},
+			},
+			errorMessage: `cell (0,0): unknown cell type "lava"`,
+		},
+		{
+			name: "cells smaller than the grid",
+			layout: Layout{
+				Width: 2, Height: 1,
+				Grid:  [][][]Circle{{{}}, {{}}},
+				Cells: [][]CellType{{Floor}},
+			},
+			errorMessage: "layout cells have 1 columns, expected 2",
+		},
+		{
+			name: "stack over the global limit",
+			layout: Layout{
+				Width: 1, Height: 1,
+				Grid:       [][][]Circle{{{Green, Red}}},
+				StackLimit: 1,
+			},
+			errorMessage: "cell (0,0) holds 2 circles but its stack limit is 1",
+		},
+		{
+			name: "cell limit raises the global one",
+			layout: Layout{
+				Width: 2, Height: 1,
+				Grid:        [][][]Circle{{{Green, Red}}, {{}}},
+				StackLimit:  1,
+				StackLimits: [][]int{{2}, {0}},
+			},
+		},
+		{
+			name: "negative cell limit",
+			layout: Layout{
+				Width: 1, Height: 1,
+				Grid:        [][][]Circle{{{}}},
+				StackLimits: [][]int{{-1}},
+			},
+			errorMessage: "cell (0,0): invalid stack limit -1",
+		},
+		{
+			name: "unknown colour",
+			layout: Layout{
+				Width: 1, Height: 1,
+				Grid: [][][]Circle{{{Green, purple}}},
+			},
+			errorMessage: `cell (0,0): unknown circle "purple"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.layout.Validate(ClassicRule{}, DefaultPalette, 1)
+
+			if tt.errorMessage == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != tt.errorMessage {
+				t.Fatalf("expected error '%s', got '%v'", tt.errorMessage, err)
+			}
+		})
+	}
+}
+
+func TestService_Reset(t *testing.T) {
+	tests := []struct {
+		name         string
+		layout       *Layout
+		expectError  bool
+		validateFunc func(*testing.T, *Service, State)
+	}{
+		{
+			name: "reset restores the starting board",
+			validateFunc: func(t *testing.T, svc *Service, state State) {
+				if state.Robots[0].PositionX != 0 || len(state.Robots[0].Holding) > 0 || len(state.Grid[0][0]) != 1 {
+					t.Fatalf("expected starting board, got %+v", state)
+				}
+				if len(svc.GetHistory()) != 0 {
+					t.Fatalf("expected empty history, got %d entries", len(svc.GetHistory()))
+				}
+			},
+		},
+		{
+			name: "reset with a layout changes the board and size",
+			layout: &Layout{
+				Width: 2, Height: 1,
+				Grid:   [][][]Circle{{{}}, {{Green, Red}}},
+				RobotX: 1,
+			},
+			validateFunc: func(t *testing.T, svc *Service, state State) {
+				if state.Width != 2 || state.Height != 1 || state.Robots[0].PositionX != 1 {
+					t.Fatalf("unexpected state %+v", state)
+				}
+				if !svc.HasWon() {
+					t.Fatalf("expected layout to already satisfy last_column")
+				}
+				svc.Move(Left)
+				again, _ := svc.Reset(nil)
+				if again.Robots[0].PositionX != 1 {
+					t.Fatalf("expected later resets to reuse the layout, got %+v", again.Robots[0])
+				}
+			},
+		},
+		{
+			name: "reset archives the game played so far",
+			validateFunc: func(t *testing.T, svc *Service, state State) {
+				svc.Reset(nil)
+
+				games, err := svc.Archive()
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(games) != 1 {
+					t.Fatalf("expected only the played game to be archived, got %d", len(games))
+				}
+				if len(games[0].History) != 2 || games[0].Final.Robots[0].PositionX != 1 || games[0].Won {
+					t.Fatalf("unexpected archived game %+v", games[0])
+				}
+			},
+		},
+		{
+			name: "reset is broadcast to subscribers",
+			validateFunc: func(t *testing.T, svc *Service, state State) {
+				events, unsubscribe := svc.Subscribe()
+				defer unsubscribe()
+
+				svc.Reset(nil)
+
+				event := <-events
+				if event.Command.Action != Reset || event.State.Robots[0].PositionX != 0 {
+					t.Fatalf("unexpected event %+v", event)
+				}
+			},
+		},
+		{
+			name: "invalid layout is rejected",
+			layout: &Layout{
+				Width: 1, Height: 1,
+				Grid: [][][]Circle{{{Red, Red}}},
+			},
+			expectError: true,
+			validateFunc: func(t *testing.T, svc *Service, state State) {
+				if games, _ := svc.Archive(); len(games) != 0 {
+					t.Fatalf("expected a rejected reset not to archive, got %d games", len(games))
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDataStore(DefaultGridSize, DefaultGridSize)
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
+			svc.config = testDefaults()
+			svc.id = "GAME1"
+			svc.store = NewMemoryStore()
+
+			svc.Pick()
+			svc.Move(Right)
+
+			state, err := svc.Reset(tt.layout)
+
+			if tt.expectError {
+				if err == nil {
+					t.Fatalf("expected error but got none")
+				}
+				if svc.GetState().Robots[0].PositionX != 1 {
+					t.Fatalf("expected a rejected reset to leave the game untouched")
+				}
+				tt.validateFunc(t, svc, state)
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			tt.validateFunc(t, svc, state)
+		})
+	}
+}
+
+func TestLoadLayout(t *testing.T) {
+	for _, path := range []string{"levels/corner.json", "levels/warehouse.json"} {
+		layout, err := LoadLayout(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := layout.Validate(ClassicRule{}, DefaultPalette, 1); err != nil {
+			t.Fatalf("expected bundled layout %s to be valid: %v", path, err)
+		}
+	}
+
+	layout, err := LoadLayout("levels/corner.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	svc, err := NewGame(GameConfig{Rules: DefaultRuleSet, Win: WinConditionConfig{Name: "last_row"}}.withDefaults(GameConfig{Layout: layout}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	state := svc.GetState()
+	if state.Width != 4 || state.Height != 2 || state.Robots[0].PositionX != 1 || state.Robots[0].PositionY != 1 {
+		t.Fatalf("unexpected state from layout %+v", state)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// LeaderboardEntry is a won game as it appears on the leaderboard. Moves
+// counts the successful commands that won it and ElapsedMS is the time in
+// milliseconds from the start of the game to the winning command. Score is
+// null for games the solver could not find an optimal solution for.
+type LeaderboardEntry struct {
+	Rank       int       `json:"rank,omitempty"`
+	GameID     string    `json:"game_id"`
+	Player     string    `json:"player,omitempty"`
+	LayoutID   string    `json:"layout_id,omitempty"`
+	Moves      int       `json:"moves"`
+	ElapsedMS  int64     `json:"elapsed_ms"`
+	EnergyUsed int       `json:"energy_used"`
+	Score      *int      `json:"score"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// leaderboardFor filters entries down to those of one layout, or returns them
+// all when layoutID is empty.
+func leaderboardFor(entries []LeaderboardEntry, layoutID string) []LeaderboardEntry {
+	matched := []LeaderboardEntry{}
+	for _, entry := range entries {
+		if layoutID == "" || entry.LayoutID == layoutID {
+			matched = append(matched, entry)
+		}
+	}
+	return matched
+}
+
+// rankLeaderboard orders entries best first: highest score, with unscored
+// games last, then fewest moves, then quickest, then earliest finished. Rank
+// counts from one.
+func rankLeaderboard(entries []LeaderboardEntry) []LeaderboardEntry {
+	points := func(entry LeaderboardEntry) int {
+		if entry.Score == nil {
+			return -1
+		}
+		return *entry.Score
+	}
+	slices.SortStableFunc(entries, func(a, b LeaderboardEntry) int {
+		return cmp.Or(
+			cmp.Compare(points(b), points(a)),
+			cmp.Compare(a.Moves, b.Moves),
+			cmp.Compare(a.ElapsedMS, b.ElapsedMS),
+			a.FinishedAt.Compare(b.FinishedAt),
+		)
+	})
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries
+}
+
+// unrankedWin is a win waiting for the optimal solution it is scored
+// against. Sequence is the history entry of the command that won it.
+type unrankedWin struct {
+	entry     LeaderboardEntry
+	sequence  int
+	startedAt time.Time
+	optimal   *optimum
+}
+
+// settle finishes the game if a committed change has won it, and unfinishes
+// it if one, such as an undo, has lost the win again, so the clock runs on.
+// Batches settle once every command has run, so a batch that is rolled back
+// never finishes the game. The caller must hold the storage lock.
+func (s *Service) settle() {
+	score := s.score(&s.storage.State)
+	if score == nil {
+		s.storage.FinishedAt = time.Time{}
+		return
+	}
+	s.finish(score)
+}
+
+// finish marks the game as won and ranks it, unless it already has been
+// since it started. The caller must hold the storage lock.
+func (s *Service) finish(score *Score) {
+	if s.storage.FinishedAt.IsZero() {
+		s.storage.FinishedAt = time.Now()
+	}
+	if s.storage.Ranked {
+		return
+	}
+	s.storage.Ranked = true
+
+	entry := LeaderboardEntry{
+		GameID:     s.id,
+		Player:     s.config.Player,
+		LayoutID:   s.leaderboardID(),
+		ElapsedMS:  s.storage.FinishedAt.Sub(s.storage.StartedAt).Milliseconds(),
+		EnergyUsed: score.EnergyUsed,
+		FinishedAt: s.storage.FinishedAt,
+	}
+	for _, record := range s.storage.History {
+		if record.Success {
+			entry.Moves++
+		}
+	}
+
+	s.unranked = &unrankedWin{
+		entry:     entry,
+		sequence:  s.storage.Sequence,
+		startedAt: s.storage.StartedAt,
+		optimal:   s.optimal,
+	}
+	s.rank()
+}
+
+// rank scores the unranked win once its optimal solution is known, both on
+// the winning command and on the leaderboard. The caller must hold the
+// storage lock.
+func (s *Service) rank() {
+	won := s.unranked
+	if won == nil {
+		return
+	}
+	score := won.optimal.rate(won.entry.EnergyUsed)
+	if score.Pending {
+		return
+	}
+	s.unranked = nil
+
+	if s.storage.StartedAt.Equal(won.startedAt) {
+		for i := range s.storage.History {
+			if s.storage.History[i].Sequence == won.sequence {
+				s.storage.History[i].Score = score.Points
+			}
+		}
+	}
+
+	if s.store == nil {
+		return
+	}
+	entry := won.entry
+	if score.Optimal > 0 {
+		entry.Score = &score.Points
+	}
+	if err := s.store.AddToLeaderboard(entry); err != nil {
+		log.Printf("adding game %s to the leaderboard: %v", s.id, err)
+	}
+}
+
+// leaderboardID names the puzzle the game is ranked on. The name is the
+// layout ID, or the board size without a layout, followed by a hash of the
+// starting board and every setting that changes how it plays, so only games
+// of the same puzzle share a leaderboard whatever a client calls its layout.
+// The caller must hold the storage lock.
+func (s *Service) leaderboardID() string {
+	puzzle, err := json.Marshal(struct {
+		Initial   State
+		Rules     string
+		Palette   Palette
+		Win       string
+		Goal      WinCondition
+		Capacity  int
+		Movement  MovementModel
+		Costs     ActionCosts
+		Energy    int
+		TimeLimit time.Duration
+	}{s.storage.Initial, s.rules.Name(), s.palette, s.win.Name(), s.win, s.capacity, s.movement, s.costs, s.energy, s.timeLimit})
+	if err != nil {
+		log.Printf("naming the leaderboard of game %s: %v", s.id, err)
+	}
+	sum := sha256.Sum256(puzzle)
+
+	name := fmt.Sprintf("%dx%d", s.storage.Initial.Width, s.storage.Initial.Height)
+	if s.config.Layout != nil && s.config.Layout.ID != "" {
+		name = s.config.Layout.ID
+	}
+	return name + "-" + hex.EncodeToString(sum[:6])
+}
+
+// LeaderboardID names the board the game is ranked on, for filtering the
+// leaderboard.
+func (s *Service) LeaderboardID() string {
+	s.storage.Mu.Lock()
+	defer s.storage.Mu.Unlock()
+	return s.leaderboardID()
+}
+
+// Leaderboard ranks the won games of one layout, or of every layout when
+// layoutID is empty.
+func (m *SessionManager) Leaderboard(layoutID string) ([]LeaderboardEntry, error) {
+	entries, err := m.store.LoadLeaderboard(layoutID)
+	if err != nil {
+		return nil, err
+	}
+	return rankLeaderboard(entries), nil
+}
@@ -1,25 +1,99 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-const GridSize = 3
+const DefaultGridSize = 3
 
 func main() {
-	dataStore := NewDataStore()
-	service := NewService(dataStore)
-	handler := NewHandler(service)
+	width := flag.Int("width", DefaultGridSize, "default number of grid columns")
+	height := flag.Int("height", DefaultGridSize, "default number of grid rows")
+	robots := flag.Int("robots", 1, "default number of robots on the grid")
+	capacity := flag.Int("capacity", 1, "default number of circles a robot can carry")
+	movement := flag.String("movement", string(DefaultMovementModel), "default movement model [four_neighbour eight_neighbour teleport]")
+	stackLimit := flag.Int("stack-limit", 0, "default number of circles a cell can hold, 0 for no limit")
+	energy := flag.Int("energy", 0, "default energy budget of a game, 0 for no budget")
+	timeLimit := flag.Duration("time-limit", 0, "default time limit of a game, 0 for untimed games")
+	ruleSet := flag.String("rules", DefaultRuleSet, fmt.Sprintf("default stacking rule set %v", RuleSetNames()))
+	winCondition := flag.String("win", DefaultWinCondition, fmt.Sprintf("default win condition %v", WinConditionNames()))
+	sessionTTL := flag.Duration("session-ttl", 30*time.Minute, "how long an idle session is kept")
+	storeKind := flag.String("store", "memory", "where games are kept [memory file]")
+	dataDir := flag.String("data-dir", "data", "directory used by the file store")
+	layoutFile := flag.String("layout", "", "JSON level file with the default starting board")
+	flag.Parse()
+
+	// Games keep their time limit in whole seconds.
+	if *timeLimit%time.Second != 0 {
+		log.Fatalf("time limit %v is not a whole number of seconds", *timeLimit)
+	}
+
+	defaults := GameConfig{
+		Width:      *width,
+		Height:     *height,
+		Robots:     *robots,
+		Capacity:   *capacity,
+		Movement:   *movement,
+		StackLimit: *stackLimit,
+		Energy:     *energy,
+		TimeLimit:  int(*timeLimit / time.Second),
+		Rules:      *ruleSet,
+		Win:        WinConditionConfig{Name: *winCondition},
+	}
+	if *layoutFile != "" {
+		layout, err := LoadLayout(*layoutFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defaults.Layout = layout
+		defaults.Width, defaults.Height = layout.Width, layout.Height
+	}
+	if _, err := NewGame(defaults); err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := NewStore(*storeKind, *dataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sessions := NewSessionManager(defaults, *sessionTTL, store)
+	restored, err := sessions.Recover()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Recovered %d saved games", restored)
+	go sessions.RunExpiry(time.Minute)
+
+	handler := NewHandler(sessions)
 
 	r := gin.Default()
 
 	r.Use(CORSMiddleware())
 
-	r.GET("/state", handler.GetState)
-	r.POST("/command", handler.ProcessCommand)
-	r.GET("/export", handler.ExportHistory)
+	r.POST("/sessions", handler.CreateSession)
+	r.GET("/leaderboard", handler.GetLeaderboard)
+
+	session := r.Group("/sessions/:id", handler.LoadSession)
+	session.GET("/state", handler.GetState)
+	session.GET("/events", handler.Events)
+	session.POST("/command", handler.ProcessCommand)
+	session.POST("/commands", handler.ProcessCommands)
+	session.POST("/program", handler.RunProgram)
+	session.GET("/export", handler.ExportHistory)
+	session.GET("/history", handler.GetHistory)
+	session.POST("/replay", handler.Replay)
+	session.GET("/solve", handler.Solve)
+	session.GET("/hint", handler.Hint)
+	session.POST("/undo", handler.Undo)
+	session.POST("/redo", handler.Redo)
+	session.POST("/reset", handler.Reset)
+	session.GET("/archive", handler.GetArchive)
 
 	log.Println("Starting server on :8080")
 	if err := r.Run(":8080"); err != nil {
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -19,6 +23,10 @@ const (
 	PickUp Action = "pick_up"
 	Drop   Action = "drop"
 	Move   Action = "move"
+	MoveTo Action = "move_to"
+	Undo   Action = "undo"
+	Redo   Action = "redo"
+	Reset  Action = "reset"
 )
 
 type Direction string
@@ -28,46 +36,329 @@ const (
 	Down  Direction = "down"
 	Left  Direction = "left"
 	Right Direction = "right"
+
+	UpLeft    Direction = "up_left"
+	UpRight   Direction = "up_right"
+	DownLeft  Direction = "down_left"
+	DownRight Direction = "down_right"
+)
+
+// CellType says what a cell allows. Floor cells allow everything; walls
+// cannot be entered, no-drop cells refuse drops and drop-only cells are
+// targets whose circles cannot be picked up again.
+type CellType string
+
+const (
+	Floor    CellType = ""
+	Wall     CellType = "wall"
+	NoDrop   CellType = "no_drop"
+	DropOnly CellType = "drop_only"
 )
 
+func knownCellType(cell CellType) bool {
+	switch cell {
+	case Floor, Wall, NoDrop, DropOnly:
+		return true
+	}
+	return false
+}
+
+// Robot carries its own stack of circles in Holding, listed bottom to top.
 type Robot struct {
-	PositionX int
-	PositionY int
-	Holding   *Circle
+	ID        string   `json:"id"`
+	PositionX int      `json:"position_x"`
+	PositionY int      `json:"position_y"`
+	Holding   []Circle `json:"holding,omitempty"`
+}
+
+// top returns the circle on top of the carried stack, or "" if there is none.
+func (r *Robot) top() Circle {
+	if len(r.Holding) == 0 {
+		return ""
+	}
+	return r.Holding[len(r.Holding)-1]
 }
 
+// State is indexed [x][y] in Grid, Cells and StackLimits. A nil Cells means
+// every cell is floor. StackLimits caps single cells and StackLimit every
+// other cell; zero means no limit. Neither changes during a game, so clones
+// share them. EnergyUsed adds up the cost of every command run so far.
 type State struct {
-	Robot Robot
-	Grid  [GridSize][GridSize][]Circle
+	Robots      []Robot
+	Width       int
+	Height      int
+	Grid        [][][]Circle
+	Cells       [][]CellType `json:",omitempty"`
+	StackLimit  int          `json:",omitempty"`
+	StackLimits [][]int      `json:",omitempty"`
+	EnergyUsed  int          `json:",omitempty"`
+}
+
+func (s *State) cell(x, y int) CellType {
+	if s.Cells == nil {
+		return Floor
+	}
+	return s.Cells[x][y]
+}
+
+// stackLimit is how many circles cell (x,y) can hold, zero if unlimited.
+func (s *State) stackLimit(x, y int) int {
+	if s.StackLimits != nil && s.StackLimits[x][y] > 0 {
+		return s.StackLimits[x][y]
+	}
+	return s.StackLimit
+}
+
+// stackLimitGrid spells out the limit of every cell, or returns nil when no
+// cell is limited.
+func (s *State) stackLimitGrid() [][]int {
+	if s.StackLimit == 0 && s.StackLimits == nil {
+		return nil
+	}
+
+	limits := make([][]int, s.Width)
+	for x := range s.Width {
+		limits[x] = make([]int, s.Height)
+		for y := range s.Height {
+			limits[x][y] = s.stackLimit(x, y)
+		}
+	}
+	return limits
+}
+
+// overfull reports the first cell holding more circles than its limit.
+func (s *State) overfull() error {
+	for x := range s.Width {
+		for y := range s.Height {
+			if limit := s.stackLimit(x, y); limit > 0 && len(s.Grid[x][y]) > limit {
+				return fmt.Errorf("cell (%d,%d) holds %d circles but its stack limit is %d",
+					x, y, len(s.Grid[x][y]), limit)
+			}
+		}
+	}
+	return nil
+}
+
+func (s State) clone() State {
+	grid := make([][][]Circle, len(s.Grid))
+	for x := range s.Grid {
+		grid[x] = make([][]Circle, len(s.Grid[x]))
+		for y := range s.Grid[x] {
+			grid[x][y] = append([]Circle{}, s.Grid[x][y]...)
+		}
+	}
+	s.Grid = grid
+
+	s.Robots = slices.Clone(s.Robots)
+	for i, robot := range s.Robots {
+		s.Robots[i].Holding = slices.Clone(robot.Holding)
+	}
+	return s
+}
+
+// robot finds the robot with the given ID. An empty ID means the first
+// robot, so single-robot clients never need to name it.
+func (s *State) robot(id string) (*Robot, error) {
+	if len(s.Robots) == 0 {
+		return nil, errors.New("there are no robots on the grid")
+	}
+	if id == "" {
+		return &s.Robots[0], nil
+	}
+	for i := range s.Robots {
+		if s.Robots[i].ID == id {
+			return &s.Robots[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown robot %q", id)
+}
+
+// robotAt returns the robot standing on cell (x,y), if any.
+func (s *State) robotAt(x, y int) *Robot {
+	for i := range s.Robots {
+		if s.Robots[i].PositionX == x && s.Robots[i].PositionY == y {
+			return &s.Robots[i]
+		}
+	}
+	return nil
+}
+
+// circles counts every circle in the game, carried ones included.
+func (s *State) circles() int {
+	total := 0
+	for _, robot := range s.Robots {
+		total += len(robot.Holding)
+	}
+	for x := range s.Grid {
+		for _, stack := range s.Grid[x] {
+			total += len(stack)
+		}
+	}
+	return total
+}
+
+// carrying reports whether any robot is holding a circle.
+func (s *State) carrying() bool {
+	for _, robot := range s.Robots {
+		if len(robot.Holding) > 0 {
+			return true
+		}
+	}
+	return false
 }
 
 type MovementHistory struct {
-	Timestamp time.Time
-	Moves     string
+	Sequence  int       `json:"sequence"`
+	Timestamp time.Time `json:"timestamp"`
+	RobotID   string    `json:"robot_id,omitempty"`
+	Action    Action    `json:"action"`
+	Direction Direction `json:"direction,omitempty"`
+	Circle    Circle    `json:"circle,omitempty"`
+	Count     int       `json:"count,omitempty"`
+	Energy    int       `json:"energy,omitempty"`
+	Score     int       `json:"score,omitempty"`
+	FromX     int       `json:"from_x"`
+	FromY     int       `json:"from_y"`
+	ToX       int       `json:"to_x"`
+	ToY       int       `json:"to_y"`
+	Success   bool      `json:"success"`
+	Error     string    `json:"error,omitempty"`
+}
+
+func (h MovementHistory) Description() string {
+	if !h.Success {
+		var command string
+		switch {
+		case h.Action == Move:
+			command = fmt.Sprintf("move %s", h.Direction)
+		case h.Action == MoveTo:
+			command = "move"
+		case h.Action == PickUp && h.Count > 1:
+			command = fmt.Sprintf("pick up %d circles", h.Count)
+		case h.Action == PickUp:
+			command = "pick up a circle"
+		case h.Action == Drop && h.Count > 1:
+			command = fmt.Sprintf("drop %d circles", h.Count)
+		case h.Action == Drop:
+			command = "drop a circle"
+		default:
+			command = string(h.Action)
+		}
+		return fmt.Sprintf("Failed to %s: %s", command, h.Error)
+	}
+
+	switch {
+	case h.Action == Move:
+		return fmt.Sprintf("Moved %s", h.Direction)
+	case h.Action == MoveTo:
+		return fmt.Sprintf("Moved to (%d,%d)", h.ToX, h.ToY)
+	case h.Action == PickUp && h.Count > 1:
+		return fmt.Sprintf("Picked up %d circles", h.Count)
+	case h.Action == PickUp:
+		return fmt.Sprintf("Picked up a %s circle", h.Circle)
+	case h.Action == Drop && h.Count > 1:
+		return fmt.Sprintf("Dropped %d circles", h.Count)
+	case h.Action == Drop:
+		return fmt.Sprintf("Dropped a %s circle", h.Circle)
+	}
+	return string(h.Action)
+}
+
+// operation is a successfully applied command, kept so it can be undone and
+// redone by swapping the state snapshots around it.
+type operation struct {
+	Before  State           `json:"before"`
+	After   State           `json:"after"`
+	History MovementHistory `json:"history"`
 }
 
+// DataStore holds a game. StartedAt is when the current board was set up and
+// FinishedAt when it was first won, zero until then.
 type DataStore struct {
-	Mu      sync.Mutex
-	State   State
-	History []MovementHistory
+	Mu         sync.Mutex
+	Initial    State
+	State      State
+	History    []MovementHistory
+	Sequence   int
+	Done       []operation
+	Undone     []operation
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	// Ranked is set once the game has gone on the leaderboard, or from the
+	// start for games that never should, so it is ranked at most once.
+	Ranked bool
+}
+
+var defaultLayout = [3][3]Circle{
+	{Red, Green, Green},
+	{Blue, Red, Blue},
+	{Green, Blue, Red},
+}
+
+// NewDataStore builds a width x height board by tiling the default 3x3 layout,
+// with a single robot in the top-left corner.
+func NewDataStore(width, height int) *DataStore {
+	return newDataStore(defaultBoard(width, height, 1))
 }
 
-func NewDataStore() *DataStore {
-	grid := [GridSize][GridSize][]Circle{
-		{{Red}, {Green}, {Green}},
-		{{Blue}, {Red}, {Blue}},
-		{{Green}, {Blue}, {Red}},
+// defaultBoard tiles the default 3x3 layout over the grid and lines robots
+// up from the top-left corner, one row after another.
+func defaultBoard(width, height, robots int) State {
+	grid := make([][][]Circle, width)
+	for x := range width {
+		grid[x] = make([][]Circle, height)
+		for y := range height {
+			grid[x][y] = []Circle{defaultLayout[x%3][y%3]}
+		}
 	}
 
+	state := State{
+		Width:  width,
+		Height: height,
+		Grid:   grid,
+	}
+	for i := range robots {
+		state.Robots = append(state.Robots, Robot{
+			ID:        robotID(i),
+			PositionX: i % width,
+			PositionY: i / width,
+		})
+	}
+	return state
+}
+
+// recolour swaps the default red, green and blue circles of the board for
+// the palette's own types, in the order the palette lists them, unless the
+// palette registers all three.
+func (s *State) recolour(palette Palette) {
+	if len(palette) == 0 || (palette.has(Red) && palette.has(Green) && palette.has(Blue)) {
+		return
+	}
+
+	types := map[Circle]Circle{}
+	for i, circle := range []Circle{Red, Green, Blue} {
+		types[circle] = palette[i%len(palette)].Name
+	}
+	for x := range s.Grid {
+		for y := range s.Grid[x] {
+			for i, circle := range s.Grid[x][y] {
+				s.Grid[x][y][i] = types[circle]
+			}
+		}
+	}
+}
+
+// robotID names the i-th robot of a board that did not name its own.
+func robotID(i int) string {
+	return strconv.Itoa(i + 1)
+}
+
+func newDataStore(initial State) *DataStore {
 	return &DataStore{
-		State: State{
-			Robot: Robot{
-				PositionX: 0,
-				PositionY: 0,
-				Holding:   nil,
-			},
-			Grid: grid,
-		},
-		History: []MovementHistory{},
+		Initial:   initial,
+		State:     initial.clone(),
+		History:   []MovementHistory{},
+		StartedAt: time.Now(),
 	}
 }
@@ -0,0 +1,114 @@
+package main
+
+import "fmt"
+
+// MovementModel decides how a robot may get around the grid. Every model
+// accepts "move_to"; the step-by-step ones path-find it through free cells,
+// teleport jumps straight there.
+type MovementModel string
+
+const (
+	FourNeighbour  MovementModel = "four_neighbour"
+	EightNeighbour MovementModel = "eight_neighbour"
+	Teleport       MovementModel = "teleport"
+)
+
+const DefaultMovementModel = FourNeighbour
+
+var directionOffsets = map[Direction][2]int{
+	Up:        {0, -1},
+	Down:      {0, 1},
+	Left:      {-1, 0},
+	Right:     {1, 0},
+	UpLeft:    {-1, -1},
+	UpRight:   {1, -1},
+	DownLeft:  {-1, 1},
+	DownRight: {1, 1},
+}
+
+var (
+	orthogonalDirections = []Direction{Up, Down, Left, Right}
+	allDirections        = []Direction{Up, Down, Left, Right, UpLeft, UpRight, DownLeft, DownRight}
+)
+
+func ParseMovementModel(name string) (MovementModel, error) {
+	switch m := MovementModel(name); m {
+	case FourNeighbour, EightNeighbour, Teleport:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown movement model %q", name)
+}
+
+// directions lists the single steps the model allows.
+func (m MovementModel) directions() []Direction {
+	if m == EightNeighbour {
+		return allDirections
+	}
+	return orthogonalDirections
+}
+
+// offset returns the change in position for one step in direction.
+func (m MovementModel) offset(direction Direction) (int, int, error) {
+	offset, ok := directionOffsets[direction]
+	if !ok {
+		return 0, 0, fmt.Errorf("unknown direction %q", direction)
+	}
+	if offset[0] != 0 && offset[1] != 0 && m != EightNeighbour {
+		return 0, 0, fmt.Errorf("cannot move %s with %s movement", direction, m)
+	}
+	return offset[0], offset[1], nil
+}
+
+// steps is the fewest moves needed to travel dx columns and dy rows on an
+// empty grid.
+func (m MovementModel) steps(dx, dy int) int {
+	dx, dy = abs(dx), abs(dy)
+	switch m {
+	case EightNeighbour:
+		return max(dx, dy)
+	case Teleport:
+		return min(dx+dy, 1)
+	}
+	return dx + dy
+}
+
+// route checks robot can get from where it stands to (x,y) and returns how
+// many steps that takes: one jump when teleporting, otherwise the shortest
+// path of free cells, avoiding walls and other robots, found breadth-first.
+func (m MovementModel) route(state *State, robot *Robot, x, y int) (int, error) {
+	if state.outOfBounds(x, y) {
+		return 0, fmt.Errorf("cell (%d,%d) is outside the grid", x, y)
+	}
+	if state.cell(x, y) == Wall {
+		return 0, fmt.Errorf("cell (%d,%d) is a wall", x, y)
+	}
+	if other := state.robotAt(x, y); other != nil && other != robot {
+		return 0, fmt.Errorf("cell (%d,%d) is occupied by robot %s", x, y, other.ID)
+	}
+	if m == Teleport {
+		return m.steps(x-robot.PositionX, y-robot.PositionY), nil
+	}
+
+	start := [2]int{robot.PositionX, robot.PositionY}
+	distance := map[[2]int]int{start: 0}
+	queue := [][2]int{start}
+	for len(queue) > 0 {
+		cell := queue[0]
+		queue = queue[1:]
+		if cell == [2]int{x, y} {
+			return distance[cell], nil
+		}
+
+		for _, direction := range m.directions() {
+			offset := directionOffsets[direction]
+			next := [2]int{cell[0] + offset[0], cell[1] + offset[1]}
+			if _, seen := distance[next]; seen || state.outOfBounds(next[0], next[1]) ||
+				state.cell(next[0], next[1]) == Wall || state.robotAt(next[0], next[1]) != nil {
+				continue
+			}
+			distance[next] = distance[cell] + 1
+			queue = append(queue, next)
+		}
+	}
+	return 0, fmt.Errorf("no free path to cell (%d,%d)", x, y)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// CircleType describes one kind of circle a game can use. Colour is how
+// clients draw it; Weight and Size are free attributes stacking rules can
+// compare.
+type CircleType struct {
+	Name   Circle `json:"name"`
+	Colour string `json:"colour"`
+	Weight int    `json:"weight,omitempty"`
+	Size   int    `json:"size,omitempty"`
+}
+
+// Palette is the set of circle types registered for a game.
+type Palette []CircleType
+
+// DefaultPalette holds the original three circles. Their sizes make green
+// the largest and red the smallest, so size ordering reads like the classic
+// rule.
+var DefaultPalette = Palette{
+	{Name: Red, Colour: "#d9534f", Weight: 1, Size: 1},
+	{Name: Green, Colour: "#5cb85c", Weight: 1, Size: 3},
+	{Name: Blue, Colour: "#0275d8", Weight: 1, Size: 2},
+}
+
+func (p Palette) Validate() error {
+	if len(p) == 0 {
+		return errors.New("the palette has no circle types")
+	}
+
+	seen := map[Circle]bool{}
+	for _, circle := range p {
+		if circle.Name == "" {
+			return errors.New("every circle type needs a name")
+		}
+		if seen[circle.Name] {
+			return fmt.Errorf("circle type %q is registered twice", circle.Name)
+		}
+		seen[circle.Name] = true
+
+		if circle.Colour == "" {
+			return fmt.Errorf("circle type %q needs a colour", circle.Name)
+		}
+		if circle.Weight < 0 || circle.Size < 0 {
+			return fmt.Errorf("circle type %q has a negative weight or size", circle.Name)
+		}
+	}
+	return nil
+}
+
+func (p Palette) lookup(circle Circle) (CircleType, bool) {
+	i := slices.IndexFunc(p, func(t CircleType) bool { return t.Name == circle })
+	if i < 0 {
+		return CircleType{}, false
+	}
+	return p[i], true
+}
+
+func (p Palette) has(circle Circle) bool {
+	_, ok := p.lookup(circle)
+	return ok
+}
+
+func (p Palette) Names() []Circle {
+	names := make([]Circle, len(p))
+	for i, circle := range p {
+		names[i] = circle.Name
+	}
+	return names
+}
+
+// CircleAttribute names a numeric attribute of a CircleType.
+type CircleAttribute string
+
+const (
+	Weight CircleAttribute = "weight"
+	Size   CircleAttribute = "size"
+)
+
+func (a CircleAttribute) of(circle CircleType) int {
+	if a == Weight {
+		return circle.Weight
+	}
+	return circle.Size
+}
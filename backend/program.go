@@ -0,0 +1,577 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"unicode"
+)
+
+// Robot programs are small scripts such as
+//
+//	proc fetch { pick ; move down }
+//	repeat 2 { move right }
+//	if not holding { fetch } else { drop }
+//	while not cell empty { pick ; move left ; drop ; move right }
+//
+// Statements are separated by newlines or semicolons; "move to <x> <y>"
+// sends the robot to a cell and pick and drop take an optional number of
+// circles. Conditions are "holding [colour]" (the top carried circle),
+// "cell empty" and "top <colour>", optionally negated with "not".
+// Everything after a '#' on a line is a comment.
+
+const (
+	MaxProgramSteps     = 10_000
+	MaxProgramCallDepth = 64
+)
+
+type ProgramError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ProgramError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+func programErrorf(line int, format string, args ...any) *ProgramError {
+	return &ProgramError{Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenNumber
+	tokenLBrace
+	tokenRBrace
+	tokenSemicolon
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+func lexProgram(source string) ([]token, error) {
+	var tokens []token
+	line := 1
+	runes := []rune(source)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == '\n':
+			line++
+			i++
+		case unicode.IsSpace(r):
+			i++
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '{':
+			tokens = append(tokens, token{kind: tokenLBrace, text: "{", line: line})
+			i++
+		case r == '}':
+			tokens = append(tokens, token{kind: tokenRBrace, text: "}", line: line})
+			i++
+		case r == ';':
+			tokens = append(tokens, token{kind: tokenSemicolon, text: ";", line: line})
+			i++
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[start:i]), line: line})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: string(runes[start:i]), line: line})
+		default:
+			return nil, programErrorf(line, "unexpected character %q", r)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, line: line}), nil
+}
+
+type conditionKind int
+
+const (
+	condHolding conditionKind = iota
+	condCellEmpty
+	condTop
+)
+
+type condition struct {
+	kind   conditionKind
+	colour Circle
+	negate bool
+}
+
+type statement interface {
+	lineNumber() int
+}
+
+type commandStatement struct {
+	line int
+	cmd  CommandRequest
+}
+
+type repeatStatement struct {
+	line  int
+	count int
+	body  []statement
+}
+
+type whileStatement struct {
+	line int
+	cond condition
+	body []statement
+}
+
+type ifStatement struct {
+	line      int
+	cond      condition
+	then      []statement
+	otherwise []statement
+}
+
+type callStatement struct {
+	line int
+	name string
+}
+
+func (s commandStatement) lineNumber() int { return s.line }
+func (s repeatStatement) lineNumber() int  { return s.line }
+func (s whileStatement) lineNumber() int   { return s.line }
+func (s ifStatement) lineNumber() int      { return s.line }
+func (s callStatement) lineNumber() int    { return s.line }
+
+type Program struct {
+	body       []statement
+	procedures map[string][]statement
+}
+
+type programParser struct {
+	tokens     []token
+	pos        int
+	procedures map[string][]statement
+}
+
+func ParseProgram(source string) (*Program, error) {
+	tokens, err := lexProgram(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &programParser{tokens: tokens, procedures: map[string][]statement{}}
+	body, err := p.parseStatements(tokenEOF)
+	if err != nil {
+		return nil, err
+	}
+
+	program := &Program{body: body, procedures: p.procedures}
+	if err := program.checkCalls(body); err != nil {
+		return nil, err
+	}
+	for _, proc := range program.procedures {
+		if err := program.checkCalls(proc); err != nil {
+			return nil, err
+		}
+	}
+
+	return program, nil
+}
+
+func (p *programParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *programParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *programParser) expect(kind tokenKind, what string) (token, error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return tok, programErrorf(tok.line, "expected %s, found %s", what, describeToken(tok))
+	}
+	return tok, nil
+}
+
+func describeToken(tok token) string {
+	if tok.kind == tokenEOF {
+		return "end of program"
+	}
+	return fmt.Sprintf("%q", tok.text)
+}
+
+// parseStatements reads statements until the given closing token, which is
+// left for the caller to consume.
+func (p *programParser) parseStatements(end tokenKind) ([]statement, error) {
+	var statements []statement
+	for {
+		tok := p.peek()
+		switch {
+		case tok.kind == end:
+			return statements, nil
+		case tok.kind == tokenSemicolon:
+			p.next()
+			continue
+		case tok.kind == tokenEOF:
+			return nil, programErrorf(tok.line, "missing closing '}'")
+		}
+
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		if stmt != nil {
+			statements = append(statements, stmt)
+		}
+	}
+}
+
+func (p *programParser) parseBlock() ([]statement, error) {
+	if _, err := p.expect(tokenLBrace, "'{'"); err != nil {
+		return nil, err
+	}
+	body, err := p.parseStatements(tokenRBrace)
+	if err != nil {
+		return nil, err
+	}
+	p.next()
+	return body, nil
+}
+
+func (p *programParser) parseStatement() (statement, error) {
+	tok := p.next()
+	if tok.kind != tokenWord {
+		return nil, programErrorf(tok.line, "expected a statement, found %s", describeToken(tok))
+	}
+
+	switch tok.text {
+	case "move":
+		dir, err := p.expect(tokenWord, "a direction")
+		if err != nil {
+			return nil, err
+		}
+		if dir.text == "to" {
+			return p.parseMoveTo(tok)
+		}
+		return commandStatement{line: tok.line, cmd: CommandRequest{Action: Move, Direction: Direction(dir.text)}}, nil
+	case "pick":
+		count, err := p.parseCount()
+		return commandStatement{line: tok.line, cmd: CommandRequest{Action: PickUp, Count: count}}, err
+	case "drop":
+		count, err := p.parseCount()
+		return commandStatement{line: tok.line, cmd: CommandRequest{Action: Drop, Count: count}}, err
+	case "repeat":
+		num, err := p.expect(tokenNumber, "a repeat count")
+		if err != nil {
+			return nil, err
+		}
+		count, err := strconv.Atoi(num.text)
+		if err != nil || count > MaxProgramSteps {
+			return nil, programErrorf(num.line, "repeat count %s is too large", num.text)
+		}
+		body, err := p.parseBlock()
+		if err != nil {
+			return nil, err
+		}
+		return repeatStatement{line: tok.line, count: count, body: body}, nil
+	case "while":
+		cond, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.parseBlock()
+		if err != nil {
+			return nil, err
+		}
+		return whileStatement{line: tok.line, cond: cond, body: body}, nil
+	case "if":
+		return p.parseIf(tok)
+	case "proc":
+		name, err := p.expect(tokenWord, "a procedure name")
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := p.procedures[name.text]; exists || isKeyword(name.text) {
+			return nil, programErrorf(name.line, "cannot define procedure %q twice or with a reserved name", name.text)
+		}
+		body, err := p.parseBlock()
+		if err != nil {
+			return nil, err
+		}
+		p.procedures[name.text] = body
+		return nil, nil
+	case "call":
+		name, err := p.expect(tokenWord, "a procedure name")
+		if err != nil {
+			return nil, err
+		}
+		return callStatement{line: tok.line, name: name.text}, nil
+	}
+
+	if isKeyword(tok.text) {
+		return nil, programErrorf(tok.line, "unexpected %q", tok.text)
+	}
+	return callStatement{line: tok.line, name: tok.text}, nil
+}
+
+// parseMoveTo reads the column and row after "move to".
+func (p *programParser) parseMoveTo(tok token) (statement, error) {
+	var cell [2]int
+	for i, what := range []string{"a column", "a row"} {
+		num, err := p.expect(tokenNumber, what)
+		if err != nil {
+			return nil, err
+		}
+		if cell[i], err = strconv.Atoi(num.text); err != nil {
+			return nil, programErrorf(num.line, "invalid cell %s", num.text)
+		}
+	}
+	return commandStatement{line: tok.line, cmd: CommandRequest{Action: MoveTo, X: &cell[0], Y: &cell[1]}}, nil
+}
+
+// parseCount reads the optional number of circles after pick or drop.
+func (p *programParser) parseCount() (int, error) {
+	if p.peek().kind != tokenNumber {
+		return 0, nil
+	}
+	num := p.next()
+	count, err := strconv.Atoi(num.text)
+	if err != nil || count < 1 {
+		return 0, programErrorf(num.line, "invalid circle count %s", num.text)
+	}
+	return count, nil
+}
+
+func (p *programParser) parseIf(tok token) (statement, error) {
+	cond, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	then, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := ifStatement{line: tok.line, cond: cond, then: then}
+	if next := p.peek(); next.kind == tokenWord && next.text == "else" {
+		p.next()
+		if after := p.peek(); after.kind == tokenWord && after.text == "if" {
+			nested, err := p.parseIf(p.next())
+			if err != nil {
+				return nil, err
+			}
+			stmt.otherwise = []statement{nested}
+		} else if stmt.otherwise, err = p.parseBlock(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *programParser) parseCondition() (condition, error) {
+	tok := p.next()
+	if tok.kind == tokenWord && tok.text == "not" {
+		cond, err := p.parseCondition()
+		cond.negate = !cond.negate
+		return cond, err
+	}
+
+	if tok.kind != tokenWord {
+		return condition{}, programErrorf(tok.line, "expected a condition, found %s", describeToken(tok))
+	}
+
+	switch tok.text {
+	case "holding":
+		cond := condition{kind: condHolding}
+		if next := p.peek(); next.kind == tokenWord && !isKeyword(next.text) {
+			cond.colour = Circle(p.next().text)
+		}
+		return cond, nil
+	case "cell":
+		if _, err := p.expectWord("empty"); err != nil {
+			return condition{}, err
+		}
+		return condition{kind: condCellEmpty}, nil
+	case "top":
+		colour, err := p.expect(tokenWord, "a colour")
+		if err != nil {
+			return condition{}, err
+		}
+		return condition{kind: condTop, colour: Circle(colour.text)}, nil
+	}
+
+	return condition{}, programErrorf(tok.line, "unknown condition %q", tok.text)
+}
+
+func (p *programParser) expectWord(word string) (token, error) {
+	tok := p.next()
+	if tok.kind != tokenWord || tok.text != word {
+		return tok, programErrorf(tok.line, "expected %q, found %s", word, describeToken(tok))
+	}
+	return tok, nil
+}
+
+func isKeyword(word string) bool {
+	switch word {
+	case "move", "pick", "drop", "repeat", "while", "if", "else", "proc", "call",
+		"not", "holding", "cell", "empty", "top":
+		return true
+	}
+	return false
+}
+
+func (p *Program) checkCalls(statements []statement) error {
+	for _, stmt := range statements {
+		var err error
+		switch stmt := stmt.(type) {
+		case callStatement:
+			if _, ok := p.procedures[stmt.name]; !ok {
+				err = programErrorf(stmt.line, "unknown procedure %q", stmt.name)
+			}
+		case repeatStatement:
+			err = p.checkCalls(stmt.body)
+		case whileStatement:
+			err = p.checkCalls(stmt.body)
+		case ifStatement:
+			if err = p.checkCalls(stmt.then); err == nil {
+				err = p.checkCalls(stmt.otherwise)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type ProgramResult struct {
+	Executed int
+	State    State
+}
+
+type interpreter struct {
+	service  *Service
+	program  *Program
+	steps    int
+	executed int
+}
+
+// RunProgram executes a parsed program through Move, Pick and Drop. Commands
+// that succeeded before an error are kept.
+func (s *Service) RunProgram(program *Program) (ProgramResult, error) {
+	in := &interpreter{service: s, program: program}
+	err := in.run(program.body, 0)
+	return ProgramResult{Executed: in.executed, State: s.GetState()}, err
+}
+
+func (in *interpreter) run(statements []statement, depth int) error {
+	for _, stmt := range statements {
+		if err := in.tick(stmt); err != nil {
+			return err
+		}
+
+		switch stmt := stmt.(type) {
+		case commandStatement:
+			if err := in.execute(stmt); err != nil {
+				return err
+			}
+		case repeatStatement:
+			for range stmt.count {
+				if err := in.tick(stmt); err != nil {
+					return err
+				}
+				if err := in.run(stmt.body, depth); err != nil {
+					return err
+				}
+			}
+		case whileStatement:
+			for in.holds(stmt.cond) {
+				if err := in.tick(stmt); err != nil {
+					return err
+				}
+				if err := in.run(stmt.body, depth); err != nil {
+					return err
+				}
+			}
+		case ifStatement:
+			branch := stmt.otherwise
+			if in.holds(stmt.cond) {
+				branch = stmt.then
+			}
+			if err := in.run(branch, depth); err != nil {
+				return err
+			}
+		case callStatement:
+			if depth >= MaxProgramCallDepth {
+				return programErrorf(stmt.line, "procedure calls nested deeper than %d", MaxProgramCallDepth)
+			}
+			if err := in.run(in.program.procedures[stmt.name], depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (in *interpreter) tick(stmt statement) error {
+	in.steps++
+	if in.steps > MaxProgramSteps {
+		return programErrorf(stmt.lineNumber(), "program exceeded %d steps", MaxProgramSteps)
+	}
+	return nil
+}
+
+func (in *interpreter) execute(stmt commandStatement) error {
+	var err error
+	switch stmt.cmd.Action {
+	case Move:
+		_, err = in.service.Move(stmt.cmd.Direction)
+	case MoveTo:
+		_, err = in.service.MoveTo(*stmt.cmd.X, *stmt.cmd.Y)
+	case PickUp:
+		_, err = in.service.PickCount(stmt.cmd.Count)
+	case Drop:
+		_, err = in.service.DropCount(stmt.cmd.Count)
+	}
+
+	if err != nil {
+		return programErrorf(stmt.line, "%v", err)
+	}
+
+	in.executed++
+	return nil
+}
+
+func (in *interpreter) holds(cond condition) bool {
+	state := in.service.GetState()
+	robot := state.Robots[0]
+	stack := state.Grid[robot.PositionX][robot.PositionY]
+
+	var result bool
+	switch cond.kind {
+	case condHolding:
+		result = len(robot.Holding) > 0 && (cond.colour == "" || robot.top() == cond.colour)
+	case condCellEmpty:
+		result = len(stack) == 0
+	case condTop:
+		result = len(stack) > 0 && stack[len(stack)-1] == cond.colour
+	}
+
+	return result != cond.negate
+}
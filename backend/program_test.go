@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseProgram(t *testing.T) {
+	tests := []struct {
+		name         string
+		source       string
+		expectError  bool
+		expectedLine int
+		errorMessage string
+	}{
+		{
+			name:   "example from the backlog",
+			source: "repeat 2 { move right } ; pick ; move down ; drop",
+		},
+		{
+			name: "procedures, conditionals and comments",
+			source: `# move the top circle one cell right
+proc shift { pick ; move right ; drop ; move left }
+if not cell empty { shift } else if holding red { drop } else { call shift }`,
+		},
+		{
+			name:   "pick and drop counts",
+			source: "pick 2 ; move right ; drop 2 ; drop",
+		},
+		{
+			name:   "move to a cell",
+			source: "move to 2 1 ; pick",
+		},
+		{
+			name:         "move to without a row",
+			source:       "move to 2",
+			expectError:  true,
+			expectedLine: 1,
+			errorMessage: "expected a row, found end of program",
+		},
+		{
+			name:         "zero count",
+			source:       "pick 0",
+			expectError:  true,
+			expectedLine: 1,
+			errorMessage: "invalid circle count 0",
+		},
+		{
+			name:         "missing direction",
+			source:       "pick\nmove\n",
+			expectError:  true,
+			expectedLine: 3,
+			errorMessage: "expected a direction, found end of program",
+		},
+		{
+			name:         "unclosed block",
+			source:       "repeat 3 {\n  move up\n",
+			expectError:  true,
+			expectedLine: 3,
+			errorMessage: "missing closing '}'",
+		},
+		{
+			name:         "unknown procedure",
+			source:       "pick\n\nfetch",
+			expectError:  true,
+			expectedLine: 3,
+			errorMessage: `unknown procedure "fetch"`,
+		},
+		{
+			name:         "unknown condition",
+			source:       "while sunny { pick }",
+			expectError:  true,
+			expectedLine: 1,
+			errorMessage: `unknown condition "sunny"`,
+		},
+		{
+			name:         "unexpected character",
+			source:       "pick\ndrop!",
+			expectError:  true,
+			expectedLine: 2,
+			errorMessage: "unexpected character '!'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseProgram(tt.source)
+
+			if !tt.expectError {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var programErr *ProgramError
+			if !errors.As(err, &programErr) {
+				t.Fatalf("expected program error, got '%v'", err)
+			}
+			if programErr.Line != tt.expectedLine || programErr.Msg != tt.errorMessage {
+				t.Fatalf("expected 'line %d: %s', got '%v'", tt.expectedLine, tt.errorMessage, err)
+			}
+		})
+	}
+}
+
+func TestService_RunProgram(t *testing.T) {
+	tests := []struct {
+		name             string
+		source           string
+		expectedExecuted int
+		expectedLine     int
+		expectError      bool
+		validateFunc     func(*testing.T, State)
+	}{
+		{
+			name:             "repeat then carry",
+			source:           "repeat 2 { move down } ; pick ; move up ; drop",
+			expectedExecuted: 5,
+			validateFunc: func(t *testing.T, state State) {
+				if len(state.Grid[0][1]) != 2 || state.Grid[0][1][1] != Green {
+					t.Fatalf("expected green circle on (0,1), got %v", state.Grid[0][1])
+				}
+			},
+		},
+		{
+			name: "while loop empties a cell",
+			source: `proc stash { pick ; move down ; drop ; move up }
+while not cell empty { stash }`,
+			expectedExecuted: 4,
+			validateFunc: func(t *testing.T, state State) {
+				if len(state.Grid[0][0]) != 0 {
+					t.Fatalf("expected (0,0) to be empty, got %v", state.Grid[0][0])
+				}
+			},
+		},
+		{
+			name:             "conditions on holding and top colour",
+			source:           "if top red { pick }\nif holding red { move right } else { move down }",
+			expectedExecuted: 2,
+			validateFunc: func(t *testing.T, state State) {
+				if state.Robots[0].PositionX != 1 || state.Robots[0].PositionY != 0 {
+					t.Fatalf("expected robot at (1,0), got (%d,%d)", state.Robots[0].PositionX, state.Robots[0].PositionY)
+				}
+			},
+		},
+		{
+			name:             "runtime error reports its line",
+			source:           "move right\nmove right\nmove right",
+			expectedExecuted: 2,
+			expectedLine:     3,
+			expectError:      true,
+			validateFunc:     func(t *testing.T, state State) {},
+		},
+		{
+			name:             "count beyond carrying capacity",
+			source:           "pick 2",
+			expectedExecuted: 0,
+			expectedLine:     1,
+			expectError:      true,
+			validateFunc:     func(t *testing.T, state State) {},
+		},
+		{
+			name:             "endless loop hits the step limit",
+			source:           "while not holding { }",
+			expectedExecuted: 0,
+			expectedLine:     1,
+			expectError:      true,
+			validateFunc:     func(t *testing.T, state State) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := ParseProgram(tt.source)
+			if err != nil {
+				t.Fatalf("unexpected parse error: %v", err)
+			}
+
+			ds := NewDataStore(DefaultGridSize, DefaultGridSize)
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
+
+			result, err := svc.RunProgram(program)
+
+			if tt.expectError {
+				var programErr *ProgramError
+				if !errors.As(err, &programErr) || programErr.Line != tt.expectedLine {
+					t.Fatalf("expected error on line %d, got '%v'", tt.expectedLine, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if result.Executed != tt.expectedExecuted {
+				t.Fatalf("expected %d executed commands, got %d", tt.expectedExecuted, result.Executed)
+			}
+
+			tt.validateFunc(t, result.State)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import "fmt"
+
+type ReplayResult struct {
+	Steps    int    `json:"steps"`
+	Diverged bool   `json:"diverged"`
+	Step     int    `json:"step,omitempty"`
+	Sequence int    `json:"sequence,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Replay resets the game to its starting grid, archiving the game it replaces
+// as Reset does, and re-applies every recorded command in order. It stops
+// after the first step whose outcome differs from the recording. A replayed
+// win is not someone's play, so it never goes on the leaderboard.
+func (s *Service) Replay(history []MovementHistory) (ReplayResult, State) {
+	s.storage.Mu.Lock()
+	defer s.storage.Mu.Unlock()
+	defer s.save()
+
+	s.archive()
+	s.restart()
+	s.storage.Ranked = true
+	s.publish(CommandRequest{Action: Reset}, s.storage.State)
+
+	var result ReplayResult
+	for i, recorded := range history {
+		result.Steps = i + 1
+
+		cmd := CommandRequest{
+			RobotID:   recorded.RobotID,
+			Action:    recorded.Action,
+			Direction: recorded.Direction,
+			Count:     recorded.Count,
+		}
+		if recorded.Action == MoveTo && recorded.Success {
+			cmd.X, cmd.Y = &recorded.ToX, &recorded.ToY
+		}
+
+		_, err := s.run(cmd)
+
+		if reason := divergence(recorded, s.storage.History[len(s.storage.History)-1], err); reason != "" {
+			result.Diverged = true
+			result.Step = i + 1
+			result.Sequence = recorded.Sequence
+			result.Reason = reason
+			return result, s.storage.State.clone()
+		}
+	}
+
+	return result, s.storage.State.clone()
+}
+
+func divergence(recorded, replayed MovementHistory, err error) string {
+	switch {
+	case recorded.Success && err != nil:
+		return fmt.Sprintf("command failed on replay: %v", err)
+	case !recorded.Success && err == nil:
+		return fmt.Sprintf("command succeeded on replay but originally failed: %s", recorded.Error)
+	case replayed.ToX != recorded.ToX || replayed.ToY != recorded.ToY:
+		return fmt.Sprintf("robot ended at (%d,%d), expected (%d,%d)",
+			replayed.ToX, replayed.ToY, recorded.ToX, recorded.ToY)
+	case recorded.Circle != "" && replayed.Circle != recorded.Circle:
+		return fmt.Sprintf("handled a %s circle, expected %s", replayed.Circle, recorded.Circle)
+	}
+	return ""
+}
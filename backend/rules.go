@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+const DefaultRuleSet = "classic"
+
+type StackingRule interface {
+	Name() string
+	Allows(stack []Circle, circle Circle) bool
+}
+
+// ClassicRule is the original policy: nothing on red, anything on green,
+// only red on blue.
+type ClassicRule struct{}
+
+func (ClassicRule) Name() string { return "classic" }
+
+func (ClassicRule) Allows(stack []Circle, circle Circle) bool {
+	if len(stack) == 0 {
+		return true
+	}
+
+	top := stack[len(stack)-1]
+
+	switch top {
+	case Red:
+		return false
+	case Green:
+		return true
+	case Blue:
+		return circle == Red
+	}
+
+	return false
+}
+
+// ColourOrderRule only allows a circle on top of a colour listed before it in
+// Order, so stacks are built strictly bottom to top.
+type ColourOrderRule struct {
+	Order []Circle
+}
+
+func (ColourOrderRule) Name() string { return "colour_order" }
+
+func (r ColourOrderRule) Allows(stack []Circle, circle Circle) bool {
+	if len(stack) == 0 {
+		return slices.Contains(r.Order, circle)
+	}
+
+	top := slices.Index(r.Order, stack[len(stack)-1])
+	next := slices.Index(r.Order, circle)
+	return top >= 0 && next > top
+}
+
+type SameColourRule struct{}
+
+func (SameColourRule) Name() string { return "same_colour" }
+
+func (SameColourRule) Allows(stack []Circle, circle Circle) bool {
+	return len(stack) == 0 || stack[len(stack)-1] == circle
+}
+
+// AttributeOrderRule only allows a circle on top of one with a strictly
+// larger Attribute, looked up in Palette, so stacks narrow towards the top
+// like a Tower of Hanoi. Circles missing from the palette are refused.
+type AttributeOrderRule struct {
+	Attribute CircleAttribute
+	Palette   Palette
+}
+
+func (r AttributeOrderRule) Name() string { return string(r.Attribute) + "_order" }
+
+func (r AttributeOrderRule) Allows(stack []Circle, circle Circle) bool {
+	next, ok := r.Palette.lookup(circle)
+	if !ok {
+		return false
+	}
+	if len(stack) == 0 {
+		return true
+	}
+
+	top, ok := r.Palette.lookup(stack[len(stack)-1])
+	return ok && r.Attribute.of(next) < r.Attribute.of(top)
+}
+
+type MaxHeightRule struct {
+	Height int
+}
+
+func (MaxHeightRule) Name() string { return "max_height" }
+
+func (r MaxHeightRule) Allows(stack []Circle, circle Circle) bool {
+	return len(stack) < r.Height
+}
+
+// RuleSet combines several rules; a drop is allowed only if every rule allows it.
+type RuleSet struct {
+	name  string
+	rules []StackingRule
+}
+
+func NewRuleSet(name string, rules ...StackingRule) *RuleSet {
+	return &RuleSet{name: name, rules: rules}
+}
+
+func (r *RuleSet) Name() string { return r.name }
+
+func (r *RuleSet) Allows(stack []Circle, circle Circle) bool {
+	return r.Violation(stack, circle) == nil
+}
+
+// Violation returns the first rule that rejects the drop, or nil.
+func (r *RuleSet) Violation(stack []Circle, circle Circle) StackingRule {
+	for _, rule := range r.rules {
+		if rejected := violatedRule(rule, stack, circle); rejected != nil {
+			return rejected
+		}
+	}
+	return nil
+}
+
+func violatedRule(rule StackingRule, stack []Circle, circle Circle) StackingRule {
+	if set, ok := rule.(*RuleSet); ok {
+		return set.Violation(stack, circle)
+	}
+	if !rule.Allows(stack, circle) {
+		return rule
+	}
+	return nil
+}
+
+// RuleSetFactory builds a rule set for a game using the given palette.
+type RuleSetFactory func(palette Palette) *RuleSet
+
+var ruleSets = map[string]RuleSetFactory{
+	"classic": func(Palette) *RuleSet {
+		return NewRuleSet("classic", ClassicRule{})
+	},
+	"strict_order": func(Palette) *RuleSet {
+		return NewRuleSet("strict_order", ColourOrderRule{Order: []Circle{Blue, Green, Red}})
+	},
+	"same_colour": func(Palette) *RuleSet {
+		return NewRuleSet("same_colour", SameColourRule{})
+	},
+	"short_stacks": func(Palette) *RuleSet {
+		return NewRuleSet("short_stacks", ClassicRule{}, MaxHeightRule{Height: 2})
+	},
+	"hanoi": func(palette Palette) *RuleSet {
+		return NewRuleSet("hanoi", AttributeOrderRule{Attribute: Size, Palette: palette})
+	},
+	"light_on_heavy": func(palette Palette) *RuleSet {
+		return NewRuleSet("light_on_heavy", AttributeOrderRule{Attribute: Weight, Palette: palette})
+	},
+}
+
+func RegisterRuleSet(name string, factory RuleSetFactory) {
+	ruleSets[name] = factory
+}
+
+func LookupRuleSet(name string, palette Palette) (*RuleSet, error) {
+	factory, ok := ruleSets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown rule set %q", name)
+	}
+	return factory(palette), nil
+}
+
+func RuleSetNames() []string {
+	return slices.Sorted(maps.Keys(ruleSets))
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ActionCosts is how much energy each successful command uses. A move costs
+// MoveLoaded while the robot carries anything and MoveEmpty otherwise; a
+// move_to costs that for every step of its route.
+type ActionCosts struct {
+	MoveEmpty  int `json:"move_empty"`
+	MoveLoaded int `json:"move_loaded"`
+	Pick       int `json:"pick"`
+	Drop       int `json:"drop"`
+}
+
+// DefaultActionCosts charges one unit per command, so energy used counts
+// commands.
+var DefaultActionCosts = ActionCosts{MoveEmpty: 1, MoveLoaded: 1, Pick: 1, Drop: 1}
+
+// maxScoredCells is the most cells a board can have for wins on it to be
+// scored. Every won game searches for its optimal solution, so the search is
+// kept to boards the solver can finish quickly.
+const maxScoredCells = 25
+
+// MaxScore is the score of a game won with no more energy than the optimal
+// solution.
+const MaxScore = 1000
+
+func (c ActionCosts) Validate() error {
+	if c.MoveEmpty < 0 || c.MoveLoaded < 0 || c.Pick < 0 || c.Drop < 0 {
+		return errors.New("action costs cannot be negative")
+	}
+	return nil
+}
+
+func (c ActionCosts) move(robot *Robot) int {
+	if len(robot.Holding) > 0 {
+		return c.MoveLoaded
+	}
+	return c.MoveEmpty
+}
+
+// cheapest is the least any single command can cost.
+func (c ActionCosts) cheapest() int {
+	return min(c.MoveEmpty, c.MoveLoaded, c.Pick, c.Drop)
+}
+
+// spend charges cost to the energy used in state, refusing the command if it
+// would go over the game's budget.
+func (s *Service) spend(state *State, cost int) error {
+	if s.energy > 0 && state.EnergyUsed+cost > s.energy {
+		return fmt.Errorf("not enough energy: the command needs %d but only %d is left",
+			cost, s.energy-state.EnergyUsed)
+	}
+	state.EnergyUsed += cost
+	return nil
+}
+
+// Score rates a won game against the cheapest solution from its starting
+// board. Efficiency is the optimal energy over the energy used and Points
+// scales it up to MaxScore. Pending is set while the cheapest solution is
+// still being searched for; Optimal is zero when the solver gave up or the
+// board is too large to search, and the game then goes unscored.
+type Score struct {
+	EnergyUsed int     `json:"energy_used"`
+	Optimal    int     `json:"optimal"`
+	Efficiency float64 `json:"efficiency"`
+	Points     int     `json:"points"`
+	Pending    bool    `json:"pending,omitempty"`
+}
+
+// Score returns the score of state, or nil if it does not meet the win
+// condition.
+func (s *Service) Score(state State) *Score {
+	s.storage.Mu.Lock()
+	defer s.storage.Mu.Unlock()
+	return s.score(&state)
+}
+
+// score rates state if it meets the win condition. It never waits for the
+// solver. The caller must hold the storage lock.
+func (s *Service) score(state *State) *Score {
+	if !s.win.Met(state) {
+		return nil
+	}
+	if s.optimal == nil {
+		s.findOptimal()
+	}
+	score := s.optimal.rate(state.EnergyUsed)
+	return &score
+}
+
+// optimum is the energy of the cheapest solution from one starting board,
+// -1 if the solver gave up. It is searched for in the background; energy and
+// solved are guarded by the storage lock, and done is closed once the result
+// has been applied.
+type optimum struct {
+	energy int
+	solved bool
+	done   chan struct{}
+}
+
+// solvedOptimum is an optimum that is already known.
+func solvedOptimum(energy int) *optimum {
+	o := &optimum{energy: energy, solved: true, done: make(chan struct{})}
+	close(o.done)
+	return o
+}
+
+// result returns the optimal energy and whether the search for it is done,
+// which it is not before it starts. The caller must hold the storage lock.
+func (o *optimum) result() (int, bool) {
+	if o == nil || !o.solved {
+		return 0, false
+	}
+	return o.energy, true
+}
+
+// rate scores a win that used energy against the optimal solution, or
+// leaves it pending until the search for one is done.
+func (o *optimum) rate(energy int) Score {
+	score := Score{EnergyUsed: energy}
+	optimal, ok := o.result()
+	switch {
+	case !ok:
+		score.Pending = true
+		return score
+	case optimal < 0:
+		return score
+	case energy <= optimal:
+		score.Efficiency = 1
+	default:
+		score.Efficiency = float64(optimal) / float64(energy)
+	}
+	score.Optimal = optimal
+	score.Points = int(math.Round(score.Efficiency * MaxScore))
+	return score
+}
+
+// findOptimal starts searching for the cheapest solution from the starting
+// board. The solver runs outside the storage lock, so no command waits on
+// it; once it is done, a win scored in the meantime is rated and ranked.
+// Boards of more than maxScoredCells cells are never searched and go
+// unscored. The caller must hold the storage lock.
+func (s *Service) findOptimal() {
+	start, win := s.storage.Initial.clone(), s.win
+	if start.Width*start.Height > maxScoredCells {
+		s.optimal = solvedOptimum(-1)
+		return
+	}
+
+	o := &optimum{done: make(chan struct{})}
+	s.optimal = o
+	go func() {
+		defer close(o.done)
+
+		energy := -1
+		if commands, err := s.solve(start.clone(), win, DefaultSolverLimits); err == nil {
+			energy = s.replayEnergy(start, commands)
+		}
+
+		s.storage.Mu.Lock()
+		defer s.storage.Mu.Unlock()
+		o.energy, o.solved = energy, true
+		if s.unranked != nil && s.unranked.optimal == o {
+			s.rank()
+		}
+		if s.optimal == o {
+			s.save()
+		}
+	}()
+}
+
+// replayEnergy is the energy commands use when run from start.
+func (s *Service) replayEnergy(start State, commands []CommandRequest) int {
+	for _, cmd := range commands {
+		if err := s.apply(&start, cmd, &MovementHistory{}); err != nil {
+			break
+		}
+	}
+	return start.EnergyUsed
+}
@@ -1,151 +1,582 @@
 package main
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
+	"log"
+	"slices"
 	"time"
 )
 
 type Service struct {
-	storage *DataStore
+	storage  *DataStore
+	rules    StackingRule
+	palette  Palette
+	win      WinCondition
+	capacity int
+	movement MovementModel
+	costs    ActionCosts
+	energy   int
+
+	// timeLimit is how long a timed game lasts from its start, zero for
+	// untimed games.
+	timeLimit time.Duration
+
+	// optimal is the search for the cheapest solution from the starting
+	// board, started by the first win, and unranked a win still waiting for
+	// it; see findOptimal.
+	optimal  *optimum
+	unranked *unrankedWin
+
+	subscribers    map[int]chan Event
+	nextSubscriber int
+
+	id     string
+	config GameConfig
+	store  Store
 }
 
-func NewService(storage *DataStore) *Service {
-	return &Service{storage: storage}
+func NewService(storage *DataStore, rules StackingRule, win WinCondition) *Service {
+	return &Service{
+		storage:  storage,
+		rules:    rules,
+		palette:  DefaultPalette,
+		win:      win,
+		capacity: 1,
+		movement: DefaultMovementModel,
+		costs:    DefaultActionCosts,
+	}
 }
 
 func (s *Service) GetState() State {
 	s.storage.Mu.Lock()
 	defer s.storage.Mu.Unlock()
-	return s.storage.State
+	return s.storage.State.clone()
 }
 
 func (s *Service) HasWon() bool {
 	s.storage.Mu.Lock()
 	defer s.storage.Mu.Unlock()
+	return s.win.Met(&s.storage.State)
+}
 
-	if s.storage.State.Robot.Holding != nil {
-		return false
-	}
+// Capacity is how many circles each robot can carry at once.
+func (s *Service) Capacity() int {
+	s.storage.Mu.Lock()
+	defer s.storage.Mu.Unlock()
+	return s.capacity
+}
 
-	for x := range GridSize - 1 {
-		for y := range GridSize {
-			if len(s.storage.State.Grid[x][y]) > 0 {
-				return false
-			}
-		}
-	}
-	return true
+// Palette lists the circle types the game was set up with.
+func (s *Service) Palette() Palette {
+	s.storage.Mu.Lock()
+	defer s.storage.Mu.Unlock()
+	return s.palette
 }
 
-func (s *Service) GetHistory() []MovementHistory {
+func (s *Service) Costs() ActionCosts {
 	s.storage.Mu.Lock()
 	defer s.storage.Mu.Unlock()
-	return s.storage.History
+	return s.costs
 }
 
-func (s *Service) Move(direction Direction) (State, error) {
+// EnergyBudget is how much energy the game allows, zero if unlimited.
+func (s *Service) EnergyBudget() int {
 	s.storage.Mu.Lock()
 	defer s.storage.Mu.Unlock()
+	return s.energy
+}
 
-	robot := &s.storage.State.Robot
+func (s *Service) Movement() MovementModel {
+	s.storage.Mu.Lock()
+	defer s.storage.Mu.Unlock()
+	return s.movement
+}
 
-	new_x, new_y := robot.PositionX, robot.PositionY
-	switch direction {
-	case Up:
-		new_y--
-	case Down:
-		new_y++
-	case Left:
-		new_x--
-	case Right:
-		new_x++
-	default:
-		return s.storage.State, nil
+func (s *Service) WinCondition() WinCondition {
+	s.storage.Mu.Lock()
+	defer s.storage.Mu.Unlock()
+	return s.win
+}
+
+// LayoutID names the layout the game started from, if it has one.
+func (s *Service) LayoutID() string {
+	s.storage.Mu.Lock()
+	defer s.storage.Mu.Unlock()
+	if s.config.Layout == nil {
+		return ""
 	}
+	return s.config.Layout.ID
+}
+
+func (s *Service) GetHistory() []MovementHistory {
+	s.storage.Mu.Lock()
+	defer s.storage.Mu.Unlock()
+	return slices.Clone(s.storage.History)
+}
 
-	if outOfBounds(new_x, new_y) {
-		return State{}, errors.New("cannot move further in that direction")
+func (s *Service) Undo() (State, error) {
+	s.storage.Mu.Lock()
+	defer s.storage.Mu.Unlock()
+	defer s.save()
+
+	if s.timeUp() {
+		return State{}, ErrTimeUp
+	}
+	if len(s.storage.Done) == 0 {
+		return State{}, errors.New("nothing to undo")
 	}
 
-	robot.PositionX, robot.PositionY = new_x, new_y
-	s.storage.History = append(s.storage.History, MovementHistory{
-		Timestamp: time.Now(),
-		Moves:     fmt.Sprintf("Moved %s", direction),
+	op := s.storage.Done[len(s.storage.Done)-1]
+	s.storage.Done = s.storage.Done[:len(s.storage.Done)-1]
+	s.storage.Undone = append(s.storage.Undone, op)
+
+	s.storage.State = op.Before.clone()
+	s.storage.History = slices.DeleteFunc(s.storage.History, func(entry MovementHistory) bool {
+		return entry.Sequence == op.History.Sequence
 	})
+	s.settle()
+	s.publish(CommandRequest{Action: Undo}, s.storage.State)
 
-	return s.storage.State, nil
+	return s.storage.State.clone(), nil
 }
 
-func (s *Service) Pick() (State, error) {
+func (s *Service) Redo() (State, error) {
 	s.storage.Mu.Lock()
 	defer s.storage.Mu.Unlock()
+	defer s.save()
 
-	robot := &s.storage.State.Robot
-	if robot.Holding != nil {
-		return State{}, errors.New("already holding a circle")
+	if s.timeUp() {
+		return State{}, ErrTimeUp
+	}
+	if len(s.storage.Undone) == 0 {
+		return State{}, errors.New("nothing to redo")
 	}
 
-	stack := s.storage.State.Grid[robot.PositionX][robot.PositionY]
-	if len(stack) == 0 {
-		return State{}, errors.New("no circles to pick up")
+	op := s.storage.Undone[len(s.storage.Undone)-1]
+	s.storage.Undone = s.storage.Undone[:len(s.storage.Undone)-1]
+
+	op.History.Sequence = s.nextSequence()
+	op.History.Timestamp = time.Now()
+	s.storage.Done = append(s.storage.Done, op)
+
+	s.storage.State = op.After.clone()
+	s.storage.History = append(s.storage.History, op.History)
+	s.settle()
+	s.publish(CommandRequest{Action: Redo}, s.storage.State)
+
+	return s.storage.State.clone(), nil
+}
+
+// Reset restarts the game from its starting board, or from layout if one is
+// given. The game played so far goes to the completed-games log and every
+// subscriber sees the fresh board.
+func (s *Service) Reset(layout *Layout) (State, error) {
+	s.storage.Mu.Lock()
+	defer s.storage.Mu.Unlock()
+
+	var (
+		win     WinCondition
+		initial State
+	)
+	if layout != nil {
+		if err := layout.Validate(s.rules, s.palette, s.capacity); err != nil {
+			return State{}, fmt.Errorf("invalid layout: %w", err)
+		}
+
+		initial = layout.State()
+		initial.StackLimit = cmp.Or(initial.StackLimit, s.config.StackLimit)
+		if err := initial.overfull(); err != nil {
+			return State{}, fmt.Errorf("invalid layout: %w", err)
+		}
+
+		var err error
+		if win, err = NewWinCondition(s.config.Win, layout.Width, layout.Height); err != nil {
+			return State{}, err
+		}
+		if err := checkRoom(win, &initial); err != nil {
+			return State{}, err
+		}
 	}
 
-	robot.Holding = &stack[len(stack)-1]
-	s.storage.State.Grid[robot.PositionX][robot.PositionY] = stack[:len(stack)-1]
-	s.storage.History = append(s.storage.History, MovementHistory{
-		Timestamp: time.Now(),
-		Moves:     fmt.Sprintf("Picked up a %s circle", *robot.Holding),
-	})
+	s.archive()
+
+	if layout != nil {
+		s.win = win
+		s.config.Layout = layout
+		s.config.Width, s.config.Height = layout.Width, layout.Height
+		s.storage.Initial = initial
+		s.optimal = nil
+	}
+
+	s.restart()
+	s.save()
+	s.publish(CommandRequest{Action: Reset}, s.storage.State)
+
+	return s.storage.State.clone(), nil
+}
+
+// archive adds the game played so far to the completed-games log, unless no
+// command was ever run. The caller must hold the storage lock.
+func (s *Service) archive() {
+	if s.store == nil || len(s.storage.History) == 0 {
+		return
+	}
 
-	return s.storage.State, nil
+	game := ArchivedGame{
+		GameID:     s.id,
+		Initial:    s.storage.Initial.clone(),
+		Final:      s.storage.State.clone(),
+		History:    s.storage.History,
+		Won:        s.win.Met(&s.storage.State),
+		Lost:       s.timeUp(),
+		ArchivedAt: time.Now(),
+	}
+	if s.config.Layout != nil {
+		game.LayoutID = s.config.Layout.ID
+	}
+
+	if err := s.store.ArchiveGame(game); err != nil {
+		log.Printf("archiving game %s: %v", s.id, err)
+	}
+}
+
+// Close archives the game played so far, as its session is about to be
+// dropped.
+func (s *Service) Close() {
+	s.storage.Mu.Lock()
+	defer s.storage.Mu.Unlock()
+	s.archive()
+}
+
+// Archive returns the games this session played before each reset.
+func (s *Service) Archive() ([]ArchivedGame, error) {
+	s.storage.Mu.Lock()
+	id, store := s.id, s.store
+	s.storage.Mu.Unlock()
+
+	if store == nil {
+		return []ArchivedGame{}, nil
+	}
+	return store.LoadArchive(id)
+}
+
+// restart puts the starting board back and forgets every command. The caller
+// must hold the storage lock.
+func (s *Service) restart() {
+	s.storage.State = s.storage.Initial.clone()
+	s.storage.History = []MovementHistory{}
+	s.storage.Sequence = 0
+	s.storage.Done = nil
+	s.storage.Undone = nil
+	s.storage.StartedAt = time.Now()
+	s.storage.FinishedAt = time.Time{}
+	s.storage.Ranked = false
+}
+
+func (s *Service) Move(direction Direction) (State, error) {
+	return s.Execute(CommandRequest{Action: Move, Direction: direction})
+}
+
+func (s *Service) Pick() (State, error) {
+	return s.Execute(CommandRequest{Action: PickUp})
 }
 
 func (s *Service) Drop() (State, error) {
+	return s.Execute(CommandRequest{Action: Drop})
+}
+
+// MoveTo sends the robot to cell (x,y) in a single command.
+func (s *Service) MoveTo(x, y int) (State, error) {
+	return s.Execute(CommandRequest{Action: MoveTo, X: &x, Y: &y})
+}
+
+// PickCount lifts the top count circles of the robot's cell onto the stack
+// it carries.
+func (s *Service) PickCount(count int) (State, error) {
+	return s.Execute(CommandRequest{Action: PickUp, Count: count})
+}
+
+// DropCount puts the top count circles the robot carries onto its cell.
+func (s *Service) DropCount(count int) (State, error) {
+	return s.Execute(CommandRequest{Action: Drop, Count: count})
+}
+
+// Execute runs a single command for the robot it names, or the first robot
+// if it names none.
+func (s *Service) Execute(cmd CommandRequest) (State, error) {
 	s.storage.Mu.Lock()
 	defer s.storage.Mu.Unlock()
+	defer s.save()
+	return s.run(cmd)
+}
 
-	robot := &s.storage.State.Robot
-	if robot.Holding == nil {
-		return State{}, errors.New("not holding any circle to drop")
+// run executes a single command and notifies subscribers if it succeeded.
+func (s *Service) run(cmd CommandRequest) (State, error) {
+	state, err := s.execute(cmd)
+	if err == nil {
+		s.settle()
+		s.publish(cmd, state)
 	}
+	return state, err
+}
 
-	stack := s.storage.State.Grid[robot.PositionX][robot.PositionY]
+// execute applies cmd to the stored state and records the outcome in the
+// history, successful or not. Callers settle the game once the command is
+// committed. The caller must hold the storage lock.
+func (s *Service) execute(cmd CommandRequest) (State, error) {
+	before := s.storage.State.clone()
+	entry := MovementHistory{
+		RobotID:   cmd.RobotID,
+		Action:    cmd.Action,
+		Direction: cmd.Direction,
+		Count:     cmd.Count,
+	}
+	if robot, err := before.robot(cmd.RobotID); err == nil {
+		entry.RobotID = robot.ID
+		entry.FromX, entry.FromY = robot.PositionX, robot.PositionY
+	}
 
-	if !canDropCircle(stack, *robot.Holding) {
-		return State{}, errors.New("cannot drop circle here due to stacking rules")
+	err := ErrTimeUp
+	if !s.timeUp() {
+		err = s.apply(&s.storage.State, cmd, &entry)
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		s.record(entry)
+		return State{}, err
 	}
 
-	dropped := *robot.Holding
-	s.storage.State.Grid[robot.PositionX][robot.PositionY] = append(stack, dropped)
-	robot.Holding = nil
-	s.storage.History = append(s.storage.History, MovementHistory{
-		Timestamp: time.Now(),
-		Moves:     fmt.Sprintf("Dropped a %s circle", dropped),
+	entry.Success = true
+	score := s.score(&s.storage.State)
+	if score != nil {
+		entry.Score = score.Points
+	}
+	s.record(entry)
+	s.storage.Done = append(s.storage.Done, operation{
+		Before:  before,
+		After:   s.storage.State.clone(),
+		History: s.storage.History[len(s.storage.History)-1],
 	})
+	s.storage.Undone = nil
 
-	return s.storage.State, nil
+	return s.storage.State.clone(), nil
 }
 
-func outOfBounds(x int, y int) bool {
-	return x < 0 || x >= GridSize || y < 0 || y >= GridSize
+// apply runs a single command against state, leaving it untouched on error.
+func (s *Service) apply(state *State, cmd CommandRequest, entry *MovementHistory) error {
+	robot, err := state.robot(cmd.RobotID)
+	if err != nil {
+		return err
+	}
+
+	entry.Circle = robot.top()
+	used := state.EnergyUsed
+
+	count := cmd.Count
+	if count == 0 {
+		count = 1
+	}
+
+	switch {
+	case count < 0:
+		err = fmt.Errorf("invalid count %d", cmd.Count)
+	case cmd.Action == Move:
+		err = s.applyMove(state, robot, cmd.Direction)
+	case cmd.Action == MoveTo:
+		err = s.applyMoveTo(state, robot, cmd.X, cmd.Y)
+	case cmd.Action == PickUp:
+		err = s.applyPick(state, robot, count)
+	case cmd.Action == Drop:
+		err = s.applyDrop(state, robot, count)
+	default:
+		err = fmt.Errorf("unknown action %q", cmd.Action)
+	}
+
+	entry.ToX, entry.ToY = robot.PositionX, robot.PositionY
+	entry.Energy = state.EnergyUsed - used
+	if entry.Circle == "" || (cmd.Action == PickUp && err == nil) {
+		// A successful pick records the picked circle, now on top of the
+		// held stack, rather than whatever was carried before.
+		entry.Circle = robot.top()
+	}
+	return err
 }
 
-func canDropCircle(stack []Circle, circle Circle) bool {
+func (s *Service) applyMove(state *State, robot *Robot, direction Direction) error {
+	dx, dy, err := s.movement.offset(direction)
+	if err != nil {
+		return err
+	}
+
+	new_x, new_y := robot.PositionX+dx, robot.PositionY+dy
+	if state.outOfBounds(new_x, new_y) {
+		return errors.New("cannot move further in that direction")
+	}
+
+	if state.cell(new_x, new_y) == Wall {
+		return fmt.Errorf("cell (%d,%d) is a wall", new_x, new_y)
+	}
+
+	if other := state.robotAt(new_x, new_y); other != nil {
+		return fmt.Errorf("cell (%d,%d) is occupied by robot %s", new_x, new_y, other.ID)
+	}
+
+	if err := s.spend(state, s.costs.move(robot)); err != nil {
+		return err
+	}
+
+	robot.PositionX, robot.PositionY = new_x, new_y
+	return nil
+}
+
+func (s *Service) applyMoveTo(state *State, robot *Robot, x, y *int) error {
+	if x == nil || y == nil {
+		return errors.New("move_to needs both x and y")
+	}
+	steps, err := s.movement.route(state, robot, *x, *y)
+	if err != nil {
+		return err
+	}
+	if err := s.spend(state, steps*s.costs.move(robot)); err != nil {
+		return err
+	}
+
+	robot.PositionX, robot.PositionY = *x, *y
+	return nil
+}
+
+func (s *Service) applyPick(state *State, robot *Robot, count int) error {
+	switch {
+	case len(robot.Holding) >= s.capacity && s.capacity == 1:
+		return errors.New("already holding a circle")
+	case len(robot.Holding) >= s.capacity:
+		return fmt.Errorf("already holding %d circles", len(robot.Holding))
+	case len(robot.Holding)+count > s.capacity:
+		return fmt.Errorf("can only carry %d more circles", s.capacity-len(robot.Holding))
+	}
+
+	if state.cell(robot.PositionX, robot.PositionY) == DropOnly {
+		return errors.New("cannot pick up circles from a drop-only zone")
+	}
+
+	stack := state.Grid[robot.PositionX][robot.PositionY]
 	if len(stack) == 0 {
-		return true
+		return errors.New("no circles to pick up")
+	}
+	if count > len(stack) {
+		return fmt.Errorf("only %d circles to pick up", len(stack))
+	}
+
+	picked := stack[len(stack)-count:]
+	for i, circle := range picked {
+		if rejected := violatedRule(s.rules, slices.Concat(robot.Holding, picked[:i]), circle); rejected != nil {
+			return fmt.Errorf("cannot carry circle on the held stack due to stacking rules: rejected by %s", rejected.Name())
+		}
+	}
+
+	if err := s.spend(state, s.costs.Pick); err != nil {
+		return err
+	}
+
+	robot.Holding = slices.Concat(robot.Holding, picked)
+	state.Grid[robot.PositionX][robot.PositionY] = stack[:len(stack)-count]
+	return nil
+}
+
+func (s *Service) applyDrop(state *State, robot *Robot, count int) error {
+	if len(robot.Holding) == 0 {
+		return errors.New("not holding any circle to drop")
+	}
+	if count > len(robot.Holding) {
+		return fmt.Errorf("only holding %d circles", len(robot.Holding))
+	}
+
+	if state.cell(robot.PositionX, robot.PositionY) == NoDrop {
+		return errors.New("cannot drop circles in a no-drop zone")
+	}
+
+	stack := state.Grid[robot.PositionX][robot.PositionY]
+	dropped := robot.Holding[len(robot.Holding)-count:]
+
+	if limit := state.stackLimit(robot.PositionX, robot.PositionY); limit > 0 && len(stack)+count > limit {
+		return fmt.Errorf("cell (%d,%d) can only hold %d circles", robot.PositionX, robot.PositionY, limit)
+	}
+
+	for i, circle := range dropped {
+		if err := s.canDropCircle(slices.Concat(stack, dropped[:i]), circle); err != nil {
+			return err
+		}
+	}
+
+	if err := s.spend(state, s.costs.Drop); err != nil {
+		return err
 	}
 
-	top := stack[len(stack)-1]
+	state.Grid[robot.PositionX][robot.PositionY] = slices.Concat(stack, dropped)
+	robot.Holding = slices.Clip(robot.Holding[:len(robot.Holding)-count])
+	return nil
+}
+
+// record stamps entry with the next sequence number and appends it to the history.
+func (s *Service) record(entry MovementHistory) {
+	entry.Sequence = s.nextSequence()
+	entry.Timestamp = time.Now()
+	s.storage.History = append(s.storage.History, entry)
+}
 
-	switch top {
-	case Red:
-		return false
-	case Green:
-		return true
-	case Blue:
-		return circle == Red
+func (s *Service) nextSequence() int {
+	s.storage.Sequence++
+	return s.storage.Sequence
+}
+
+func (s *Service) Snapshot() GameRecord {
+	s.storage.Mu.Lock()
+	defer s.storage.Mu.Unlock()
+	return s.snapshot()
+}
+
+// snapshot returns the persisted form of the game. The caller must hold the
+// storage lock.
+func (s *Service) snapshot() GameRecord {
+	record := GameRecord{
+		ID:         s.id,
+		Config:     s.config,
+		Initial:    s.storage.Initial.clone(),
+		State:      s.storage.State.clone(),
+		History:    slices.Clone(s.storage.History),
+		Sequence:   s.storage.Sequence,
+		Done:       slices.Clone(s.storage.Done),
+		Undone:     slices.Clone(s.storage.Undone),
+		StartedAt:  s.storage.StartedAt,
+		FinishedAt: s.storage.FinishedAt,
+		Ranked:     s.storage.Ranked,
+	}
+	if optimal, ok := s.optimal.result(); ok {
+		record.Optimal = &optimal
+	}
+	return record
+}
+
+// save writes the game to its store, if it has one. The caller must hold the
+// storage lock.
+func (s *Service) save() {
+	if s.store == nil {
+		return
 	}
+	if err := s.store.SaveGame(s.snapshot()); err != nil {
+		log.Printf("saving game %s: %v", s.id, err)
+	}
+}
 
-	return false
+func (s *State) outOfBounds(x int, y int) bool {
+	return x < 0 || x >= s.Width || y < 0 || y >= s.Height
+}
+
+func (s *Service) canDropCircle(stack []Circle, circle Circle) error {
+	if rejected := violatedRule(s.rules, stack, circle); rejected != nil {
+		return fmt.Errorf("cannot drop circle here due to stacking rules: rejected by %s", rejected.Name())
+	}
+	return nil
 }
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestService_Move(t *testing.T) {
@@ -95,11 +101,11 @@ func TestService_Move(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ds := NewDataStore()
-			ds.State.Robot.PositionX = tt.initialX
-			ds.State.Robot.PositionY = tt.initialY
+			ds := NewDataStore(DefaultGridSize, DefaultGridSize)
+			ds.State.Robots[0].PositionX = tt.initialX
+			ds.State.Robots[0].PositionY = tt.initialY
 
-			svc := NewService(ds)
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
 			state, err := svc.Move(tt.direction)
 
 			if tt.expectError {
@@ -113,9 +119,9 @@ func TestService_Move(t *testing.T) {
 				t.Fatalf("unexpected error: %v", err)
 			}
 
-			if state.Robot.PositionX != tt.expectedX || state.Robot.PositionY != tt.expectedY {
+			if state.Robots[0].PositionX != tt.expectedX || state.Robots[0].PositionY != tt.expectedY {
 				t.Fatalf("expected position (%d,%d), got (%d,%d)",
-					tt.expectedX, tt.expectedY, state.Robot.PositionX, state.Robot.PositionY)
+					tt.expectedX, tt.expectedY, state.Robots[0].PositionX, state.Robots[0].PositionY)
 			}
 		})
 	}
@@ -134,8 +140,8 @@ func TestService_Pick(t *testing.T) {
 		{
 			name: "pick circle from position",
 			setupFunc: func(ds *DataStore) {
-				ds.State.Robot.PositionX = 2
-				ds.State.Robot.PositionY = 2
+				ds.State.Robots[0].PositionX = 2
+				ds.State.Robots[0].PositionY = 2
 				ds.State.Grid[2][2] = []Circle{redCircle}
 			},
 			expectedCircle: &redCircle,
@@ -144,8 +150,8 @@ func TestService_Pick(t *testing.T) {
 		{
 			name: "pick from empty stack",
 			setupFunc: func(ds *DataStore) {
-				ds.State.Robot.PositionX = 2
-				ds.State.Robot.PositionY = 2
+				ds.State.Robots[0].PositionX = 2
+				ds.State.Robots[0].PositionY = 2
 				ds.State.Grid[2][2] = []Circle{}
 			},
 			expectedCircle: nil,
@@ -156,7 +162,7 @@ func TestService_Pick(t *testing.T) {
 			name: "pick when already holding",
 			setupFunc: func(ds *DataStore) {
 				circle := Red
-				ds.State.Robot.Holding = &circle
+				ds.State.Robots[0].Holding = []Circle{circle}
 			},
 			expectedCircle: nil,
 			expectError:    true,
@@ -166,10 +172,10 @@ func TestService_Pick(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ds := NewDataStore()
+			ds := NewDataStore(DefaultGridSize, DefaultGridSize)
 			tt.setupFunc(ds)
 
-			svc := NewService(ds)
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
 
 			state, err := svc.Pick()
 
@@ -185,12 +191,12 @@ func TestService_Pick(t *testing.T) {
 			}
 
 			if tt.expectedCircle == nil {
-				if state.Robot.Holding != nil {
-					t.Fatalf("expected robot to hold nothing, got %v", state.Robot.Holding)
+				if len(state.Robots[0].Holding) > 0 {
+					t.Fatalf("expected robot to hold nothing, got %v", state.Robots[0].Holding)
 				}
 			} else {
-				if *state.Robot.Holding != *tt.expectedCircle {
-					t.Fatalf("expected robot to hold %v, got %v", *tt.expectedCircle, *state.Robot.Holding)
+				if state.Robots[0].top() != *tt.expectedCircle {
+					t.Fatalf("expected robot to hold %v, got %v", *tt.expectedCircle, state.Robots[0].top())
 				}
 			}
 		})
@@ -210,9 +216,9 @@ func TestService_Drop(t *testing.T) {
 		{
 			name: "drop on empty stack",
 			setupFunc: func(ds *DataStore) {
-				ds.State.Robot.PositionX = 1
-				ds.State.Robot.PositionY = 1
-				ds.State.Robot.Holding = &redCircle
+				ds.State.Robots[0].PositionX = 1
+				ds.State.Robots[0].PositionY = 1
+				ds.State.Robots[0].Holding = []Circle{redCircle}
 				ds.State.Grid[1][1] = []Circle{}
 			},
 			assertState: func(t *testing.T, state State) {
@@ -220,8 +226,8 @@ func TestService_Drop(t *testing.T) {
 					t.Fatalf("expected red circle on stack, got %v", state.Grid[1][1])
 				}
 
-				if state.Robot.Holding != nil {
-					t.Fatalf("expected robot to hold nothing after drop, got %v", state.Robot.Holding)
+				if len(state.Robots[0].Holding) > 0 {
+					t.Fatalf("expected robot to hold nothing after drop, got %v", state.Robots[0].Holding)
 				}
 			},
 			expectError: false,
@@ -229,9 +235,9 @@ func TestService_Drop(t *testing.T) {
 		{
 			name: "drop when not holding",
 			setupFunc: func(ds *DataStore) {
-				ds.State.Robot.PositionX = 1
-				ds.State.Robot.PositionY = 1
-				ds.State.Robot.Holding = nil
+				ds.State.Robots[0].PositionX = 1
+				ds.State.Robots[0].PositionY = 1
+				ds.State.Robots[0].Holding = nil
 			},
 			assertState:  func(t *testing.T, state State) {},
 			expectError:  true,
@@ -240,10 +246,9 @@ func TestService_Drop(t *testing.T) {
 		{
 			name: "drop blue on red circle",
 			setupFunc: func(ds *DataStore) {
-				blueCircle := Blue
-				ds.State.Robot.PositionX = 1
-				ds.State.Robot.PositionY = 1
-				ds.State.Robot.Holding = &blueCircle
+				ds.State.Robots[0].PositionX = 1
+				ds.State.Robots[0].PositionY = 1
+				ds.State.Robots[0].Holding = []Circle{Blue}
 				ds.State.Grid[1][1] = []Circle{Red}
 			},
 			assertState:  func(t *testing.T, state State) {},
@@ -253,10 +258,9 @@ func TestService_Drop(t *testing.T) {
 		{
 			name: "drop green on red circle",
 			setupFunc: func(ds *DataStore) {
-				greenCircle := Green
-				ds.State.Robot.PositionX = 1
-				ds.State.Robot.PositionY = 1
-				ds.State.Robot.Holding = &greenCircle
+				ds.State.Robots[0].PositionX = 1
+				ds.State.Robots[0].PositionY = 1
+				ds.State.Robots[0].Holding = []Circle{Green}
 				ds.State.Grid[1][1] = []Circle{Red}
 			},
 			assertState:  func(t *testing.T, state State) {},
@@ -266,9 +270,9 @@ func TestService_Drop(t *testing.T) {
 		{
 			name: "drop red on red circle",
 			setupFunc: func(ds *DataStore) {
-				ds.State.Robot.PositionX = 1
-				ds.State.Robot.PositionY = 1
-				ds.State.Robot.Holding = &redCircle
+				ds.State.Robots[0].PositionX = 1
+				ds.State.Robots[0].PositionY = 1
+				ds.State.Robots[0].Holding = []Circle{redCircle}
 				ds.State.Grid[1][1] = []Circle{Red}
 			},
 			assertState:  func(t *testing.T, state State) {},
@@ -278,10 +282,9 @@ func TestService_Drop(t *testing.T) {
 		{
 			name: "drop green on blue circle",
 			setupFunc: func(ds *DataStore) {
-				greenCircle := Green
-				ds.State.Robot.PositionX = 1
-				ds.State.Robot.PositionY = 1
-				ds.State.Robot.Holding = &greenCircle
+				ds.State.Robots[0].PositionX = 1
+				ds.State.Robots[0].PositionY = 1
+				ds.State.Robots[0].Holding = []Circle{Green}
 				ds.State.Grid[1][1] = []Circle{Blue}
 			},
 			assertState:  func(t *testing.T, state State) {},
@@ -291,9 +294,9 @@ func TestService_Drop(t *testing.T) {
 		{
 			name: "drop red on blue circle",
 			setupFunc: func(ds *DataStore) {
-				ds.State.Robot.PositionX = 1
-				ds.State.Robot.PositionY = 1
-				ds.State.Robot.Holding = &redCircle
+				ds.State.Robots[0].PositionX = 1
+				ds.State.Robots[0].PositionY = 1
+				ds.State.Robots[0].Holding = []Circle{redCircle}
 				ds.State.Grid[1][1] = []Circle{Blue}
 			},
 			assertState: func(t *testing.T, state State) {
@@ -301,8 +304,8 @@ func TestService_Drop(t *testing.T) {
 					t.Fatalf("expected red circle on top of blue, got %v", state.Grid[1][1])
 				}
 
-				if state.Robot.Holding != nil {
-					t.Fatalf("expected robot to hold nothing after drop, got %v", state.Robot.Holding)
+				if len(state.Robots[0].Holding) > 0 {
+					t.Fatalf("expected robot to hold nothing after drop, got %v", state.Robots[0].Holding)
 				}
 			},
 			expectError: false,
@@ -311,9 +314,9 @@ func TestService_Drop(t *testing.T) {
 			name: "drop blue on blue circle",
 			setupFunc: func(ds *DataStore) {
 				blueCircle := Blue
-				ds.State.Robot.PositionX = 1
-				ds.State.Robot.PositionY = 1
-				ds.State.Robot.Holding = &blueCircle
+				ds.State.Robots[0].PositionX = 1
+				ds.State.Robots[0].PositionY = 1
+				ds.State.Robots[0].Holding = []Circle{blueCircle}
 				ds.State.Grid[1][1] = []Circle{Blue}
 			},
 			assertState:  func(t *testing.T, state State) {},
@@ -323,10 +326,9 @@ func TestService_Drop(t *testing.T) {
 		{
 			name: "drop blue on green circle",
 			setupFunc: func(ds *DataStore) {
-				blueCircle := Blue
-				ds.State.Robot.PositionX = 1
-				ds.State.Robot.PositionY = 1
-				ds.State.Robot.Holding = &blueCircle
+				ds.State.Robots[0].PositionX = 1
+				ds.State.Robots[0].PositionY = 1
+				ds.State.Robots[0].Holding = []Circle{Blue}
 				ds.State.Grid[1][1] = []Circle{Green}
 			},
 			assertState: func(t *testing.T, state State) {
@@ -334,8 +336,8 @@ func TestService_Drop(t *testing.T) {
 					t.Fatalf("expected blue circle on top, got %v", state.Grid[1][1])
 				}
 
-				if state.Robot.Holding != nil {
-					t.Fatalf("expected robot to hold nothing after drop, got %v", state.Robot.Holding)
+				if len(state.Robots[0].Holding) > 0 {
+					t.Fatalf("expected robot to hold nothing after drop, got %v", state.Robots[0].Holding)
 				}
 			},
 			expectError: false,
@@ -343,10 +345,9 @@ func TestService_Drop(t *testing.T) {
 		{
 			name: "drop green on green circle",
 			setupFunc: func(ds *DataStore) {
-				greenCircle := Green
-				ds.State.Robot.PositionX = 1
-				ds.State.Robot.PositionY = 1
-				ds.State.Robot.Holding = &greenCircle
+				ds.State.Robots[0].PositionX = 1
+				ds.State.Robots[0].PositionY = 1
+				ds.State.Robots[0].Holding = []Circle{Green}
 				ds.State.Grid[1][1] = []Circle{Green}
 			},
 			assertState: func(t *testing.T, state State) {
@@ -354,8 +355,8 @@ func TestService_Drop(t *testing.T) {
 					t.Fatalf("expected green circle on top, got %v", state.Grid[1][1])
 				}
 
-				if state.Robot.Holding != nil {
-					t.Fatalf("expected robot to hold nothing after drop, got %v", state.Robot.Holding)
+				if len(state.Robots[0].Holding) > 0 {
+					t.Fatalf("expected robot to hold nothing after drop, got %v", state.Robots[0].Holding)
 				}
 			},
 			expectError: false,
@@ -363,9 +364,9 @@ func TestService_Drop(t *testing.T) {
 		{
 			name: "drop red on green circle",
 			setupFunc: func(ds *DataStore) {
-				ds.State.Robot.PositionX = 1
-				ds.State.Robot.PositionY = 1
-				ds.State.Robot.Holding = &redCircle
+				ds.State.Robots[0].PositionX = 1
+				ds.State.Robots[0].PositionY = 1
+				ds.State.Robots[0].Holding = []Circle{redCircle}
 				ds.State.Grid[1][1] = []Circle{Green}
 			},
 			assertState: func(t *testing.T, state State) {
@@ -373,8 +374,8 @@ func TestService_Drop(t *testing.T) {
 					t.Fatalf("expected red circle on top, got %v", state.Grid[1][1])
 				}
 
-				if state.Robot.Holding != nil {
-					t.Fatalf("expected robot to hold nothing after drop, got %v", state.Robot.Holding)
+				if len(state.Robots[0].Holding) > 0 {
+					t.Fatalf("expected robot to hold nothing after drop, got %v", state.Robots[0].Holding)
 				}
 			},
 			expectError: false,
@@ -383,10 +384,10 @@ func TestService_Drop(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ds := NewDataStore()
+			ds := NewDataStore(DefaultGridSize, DefaultGridSize)
 			tt.setupFunc(ds)
 
-			svc := NewService(ds)
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
 
 			state, err := svc.Drop()
 
@@ -406,6 +407,111 @@ func TestService_Drop(t *testing.T) {
 	}
 }
 
+func TestService_DropWithRuleSets(t *testing.T) {
+	tests := []struct {
+		name         string
+		ruleSet      string
+		stack        []Circle
+		holding      Circle
+		expectError  bool
+		errorMessage string
+	}{
+		{
+			name:    "strict order allows red on green",
+			ruleSet: "strict_order",
+			stack:   []Circle{Blue, Green},
+			holding: Red,
+		},
+		{
+			name:         "strict order rejects blue on green",
+			ruleSet:      "strict_order",
+			stack:        []Circle{Green},
+			holding:      Blue,
+			expectError:  true,
+			errorMessage: "cannot drop circle here due to stacking rules: rejected by colour_order",
+		},
+		{
+			name:    "same colour allows green on green",
+			ruleSet: "same_colour",
+			stack:   []Circle{Green},
+			holding: Green,
+		},
+		{
+			name:         "same colour rejects red on green",
+			ruleSet:      "same_colour",
+			stack:        []Circle{Green},
+			holding:      Red,
+			expectError:  true,
+			errorMessage: "cannot drop circle here due to stacking rules: rejected by same_colour",
+		},
+		{
+			name:         "short stacks rejects a third circle",
+			ruleSet:      "short_stacks",
+			stack:        []Circle{Green, Green},
+			holding:      Red,
+			expectError:  true,
+			errorMessage: "cannot drop circle here due to stacking rules: rejected by max_height",
+		},
+		{
+			name:         "short stacks still applies classic rules",
+			ruleSet:      "short_stacks",
+			stack:        []Circle{Red},
+			holding:      Red,
+			expectError:  true,
+			errorMessage: "cannot drop circle here due to stacking rules: rejected by classic",
+		},
+		{
+			name:    "hanoi allows a smaller circle on a larger one",
+			ruleSet: "hanoi",
+			stack:   []Circle{Green, Blue},
+			holding: Red,
+		},
+		{
+			name:         "hanoi rejects a larger circle on a smaller one",
+			ruleSet:      "hanoi",
+			stack:        []Circle{Blue},
+			holding:      Green,
+			expectError:  true,
+			errorMessage: "cannot drop circle here due to stacking rules: rejected by size_order",
+		},
+		{
+			name:         "hanoi rejects circles of the same size",
+			ruleSet:      "hanoi",
+			stack:        []Circle{Blue},
+			holding:      Blue,
+			expectError:  true,
+			errorMessage: "cannot drop circle here due to stacking rules: rejected by size_order",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := LookupRuleSet(tt.ruleSet, DefaultPalette)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			ds := NewDataStore(DefaultGridSize, DefaultGridSize)
+			ds.State.Robots[0].Holding = []Circle{tt.holding}
+			ds.State.Grid[0][0] = tt.stack
+
+			svc := NewService(ds, rules, LastColumnCondition{})
+			_, err = svc.Drop()
+
+			if tt.expectError {
+				if err == nil || err.Error() != tt.errorMessage {
+					t.Fatalf("expected error '%s', got '%v'", tt.errorMessage, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
 func TestService_GetState(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -414,21 +520,21 @@ func TestService_GetState(t *testing.T) {
 		{
 			name: "get state successfully",
 			validateFunc: func(t *testing.T, state State) {
-				if state.Robot.PositionX != 0 || state.Robot.PositionY != 0 {
+				if state.Robots[0].PositionX != 0 || state.Robots[0].PositionY != 0 {
 					t.Fatalf("expected robot at (0,0), got (%d,%d)",
-						state.Robot.PositionX, state.Robot.PositionY)
+						state.Robots[0].PositionX, state.Robots[0].PositionY)
 				}
-				if state.Robot.Holding != nil {
-					t.Fatalf("expected robot to hold nothing initially, got %v", state.Robot.Holding)
+				if len(state.Robots[0].Holding) > 0 {
+					t.Fatalf("expected robot to hold nothing initially, got %v", state.Robots[0].Holding)
 				}
 
-				expectedGrid := [GridSize][GridSize][]Circle{
+				expectedGrid := [][][]Circle{
 					{{Red}, {Green}, {Green}},
 					{{Blue}, {Red}, {Blue}},
 					{{Green}, {Blue}, {Red}},
 				}
-				for x := 0; x < GridSize; x++ {
-					for y := 0; y < GridSize; y++ {
+				for x := 0; x < DefaultGridSize; x++ {
+					for y := 0; y < DefaultGridSize; y++ {
 						expectedStack := expectedGrid[x][y]
 						actualStack := state.Grid[x][y]
 						if len(expectedStack) != len(actualStack) {
@@ -449,57 +555,320 @@ func TestService_GetState(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ds := NewDataStore()
-			svc := NewService(ds)
+			ds := NewDataStore(DefaultGridSize, DefaultGridSize)
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
 			state := svc.GetState()
 			tt.validateFunc(t, state)
 		})
 	}
 }
 
-func TestService_GetHistory(t *testing.T) {
+func TestService_RectangularGrid(t *testing.T) {
 	tests := []struct {
 		name         string
-		setupFunc    func(*Service)
-		validateFunc func(*testing.T, []MovementHistory)
+		width        int
+		height       int
+		setupFunc    func(*DataStore)
+		validateFunc func(*testing.T, *Service)
 	}{
 		{
-			name:      "empty history initially",
-			setupFunc: func(svc *Service) {},
-			validateFunc: func(t *testing.T, history []MovementHistory) {
-				if len(history) != 0 {
-					t.Fatalf("expected empty history, got length %d", len(history))
+			name:      "grid carries its dimensions",
+			width:     4,
+			height:    7,
+			setupFunc: func(ds *DataStore) {},
+			validateFunc: func(t *testing.T, svc *Service) {
+				state := svc.GetState()
+				if state.Width != 4 || state.Height != 7 {
+					t.Fatalf("expected 4x7 grid, got %dx%d", state.Width, state.Height)
+				}
+				if len(state.Grid) != 4 || len(state.Grid[0]) != 7 {
+					t.Fatalf("expected grid slices of 4x7, got %dx%d", len(state.Grid), len(state.Grid[0]))
 				}
 			},
 		},
 		{
-			name: "history after one move",
-			setupFunc: func(svc *Service) {
+			name:   "move within a wide grid",
+			width:  5,
+			height: 2,
+			setupFunc: func(ds *DataStore) {
+				ds.State.Robots[0].PositionX = 3
+			},
+			validateFunc: func(t *testing.T, svc *Service) {
+				state, err := svc.Move(Right)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if state.Robots[0].PositionX != 4 {
+					t.Fatalf("expected robot at x=4, got %d", state.Robots[0].PositionX)
+				}
+				if _, err := svc.Move(Right); err == nil {
+					t.Fatalf("expected out of bounds error past x=4")
+				}
+			},
+		},
+		{
+			name:   "move past the bottom of a short grid",
+			width:  5,
+			height: 2,
+			setupFunc: func(ds *DataStore) {
+				ds.State.Robots[0].PositionY = 1
+			},
+			validateFunc: func(t *testing.T, svc *Service) {
+				if _, err := svc.Move(Down); err == nil {
+					t.Fatalf("expected out of bounds error past y=1")
+				}
+			},
+		},
+		{
+			name:   "won when only the last row holds circles",
+			width:  4,
+			height: 2,
+			setupFunc: func(ds *DataStore) {
+				for x := range 3 {
+					for y := range 2 {
+						ds.State.Grid[x][y] = []Circle{}
+					}
+				}
+			},
+			validateFunc: func(t *testing.T, svc *Service) {
+				if !svc.HasWon() {
+					t.Fatalf("expected game to be won")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDataStore(tt.width, tt.height)
+			tt.setupFunc(ds)
+
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
+
+			tt.validateFunc(t, svc)
+		})
+	}
+}
+
+func TestService_MultipleRobots(t *testing.T) {
+	tests := []struct {
+		name         string
+		validateFunc func(*testing.T, *Service)
+	}{
+		{
+			name: "robots move independently",
+			validateFunc: func(t *testing.T, svc *Service) {
+				state, err := svc.Execute(CommandRequest{RobotID: "2", Action: Move, Direction: Down})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if state.Robots[1].PositionY != 1 || state.Robots[0].PositionY != 0 {
+					t.Fatalf("expected only robot 2 to move, got %+v", state.Robots)
+				}
+			},
+		},
+		{
+			name: "commands without a robot ID drive the first robot",
+			validateFunc: func(t *testing.T, svc *Service) {
+				state, err := svc.Move(Down)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if state.Robots[0].PositionY != 1 {
+					t.Fatalf("expected robot 1 to move, got %+v", state.Robots)
+				}
+			},
+		},
+		{
+			name: "robots cannot share a cell",
+			validateFunc: func(t *testing.T, svc *Service) {
+				_, err := svc.Execute(CommandRequest{RobotID: "1", Action: Move, Direction: Right})
+				if err == nil || err.Error() != "cell (1,0) is occupied by robot 2" {
+					t.Fatalf("expected collision error, got %v", err)
+				}
+			},
+		},
+		{
+			name: "each robot holds its own circle",
+			validateFunc: func(t *testing.T, svc *Service) {
+				svc.Execute(CommandRequest{RobotID: "1", Action: PickUp})
+				state, err := svc.Execute(CommandRequest{RobotID: "2", Action: PickUp})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if state.Robots[0].top() != Red || state.Robots[1].top() != Blue {
+					t.Fatalf("unexpected holdings %+v", state.Robots)
+				}
+
+				history := svc.GetHistory()
+				if history[0].RobotID != "1" || history[1].RobotID != "2" {
+					t.Fatalf("expected history to name the robots, got %+v", history)
+				}
+			},
+		},
+		{
+			name: "unknown robot",
+			validateFunc: func(t *testing.T, svc *Service) {
+				_, err := svc.Execute(CommandRequest{RobotID: "9", Action: PickUp})
+				if err == nil || err.Error() != `unknown robot "9"` {
+					t.Fatalf("expected unknown robot error, got %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := newDataStore(defaultBoard(DefaultGridSize, DefaultGridSize, 2))
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
+
+			tt.validateFunc(t, svc)
+		})
+	}
+}
+
+func TestService_CarryingCapacity(t *testing.T) {
+	tests := []struct {
+		name         string
+		capacity     int
+		setupFunc    func(*DataStore)
+		validateFunc func(*testing.T, *Service)
+	}{
+		{
+			name:     "pick several circles at once",
+			capacity: 3,
+			validateFunc: func(t *testing.T, svc *Service) {
+				state, err := svc.PickCount(2)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !slices.Equal(state.Robots[0].Holding, []Circle{Blue, Red}) {
+					t.Fatalf("expected to carry [blue red], got %v", state.Robots[0].Holding)
+				}
+				if !slices.Equal(state.Grid[0][0], []Circle{Green}) {
+					t.Fatalf("expected [green] left behind, got %v", state.Grid[0][0])
+				}
+				if got := svc.GetHistory()[0].Description(); got != "Picked up 2 circles" {
+					t.Fatalf("unexpected description %q", got)
+				}
+			},
+		},
+		{
+			name:     "pick beyond capacity",
+			capacity: 2,
+			validateFunc: func(t *testing.T, svc *Service) {
+				svc.Pick()
+				_, err := svc.PickCount(2)
+				if err == nil || err.Error() != "can only carry 1 more circles" {
+					t.Fatalf("expected capacity error, got %v", err)
+				}
+			},
+		},
+		{
+			name:     "pick more than the stack holds",
+			capacity: 5,
+			validateFunc: func(t *testing.T, svc *Service) {
+				_, err := svc.PickCount(4)
+				if err == nil || err.Error() != "only 3 circles to pick up" {
+					t.Fatalf("expected stack size error, got %v", err)
+				}
+			},
+		},
+		{
+			name:     "carried stack follows the stacking rules",
+			capacity: 2,
+			setupFunc: func(ds *DataStore) {
+				ds.State.Robots[0].Holding = []Circle{Red}
+				ds.State.Grid[0][0] = []Circle{Blue}
+			},
+			validateFunc: func(t *testing.T, svc *Service) {
+				if _, err := svc.Pick(); err == nil {
+					t.Fatalf("expected blue on red to be rejected while carrying")
+				}
+			},
+		},
+		{
+			name:     "pick while carrying records the picked circle",
+			capacity: 2,
+			setupFunc: func(ds *DataStore) {
+				ds.State.Robots[0].Holding = []Circle{Green}
+				ds.State.Grid[0][0] = []Circle{Blue}
+			},
+			validateFunc: func(t *testing.T, svc *Service) {
+				if _, err := svc.Pick(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got := svc.GetHistory()[0].Description(); got != "Picked up a blue circle" {
+					t.Fatalf("unexpected description %q", got)
+				}
+			},
+		},
+		{
+			name:     "drop several circles at once",
+			capacity: 3,
+			setupFunc: func(ds *DataStore) {
+				ds.State.Grid[1][0] = []Circle{Green}
+			},
+			validateFunc: func(t *testing.T, svc *Service) {
+				svc.PickCount(2)
 				svc.Move(Right)
+				state, err := svc.DropCount(2)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !slices.Equal(state.Grid[1][0], []Circle{Green, Blue, Red}) {
+					t.Fatalf("expected [green blue red], got %v", state.Grid[1][0])
+				}
+				if len(state.Robots[0].Holding) != 0 {
+					t.Fatalf("expected to carry nothing, got %v", state.Robots[0].Holding)
+				}
 			},
-			validateFunc: func(t *testing.T, history []MovementHistory) {
-				if history[0].Moves != "Moved right" {
-					t.Fatalf("expected 'Moved right', got '%s'", history[0].Moves)
+		},
+		{
+			name:     "drop checks the destination stack",
+			capacity: 3,
+			setupFunc: func(ds *DataStore) {
+				ds.State.Grid[1][0] = []Circle{Red}
+			},
+			validateFunc: func(t *testing.T, svc *Service) {
+				svc.PickCount(2)
+				svc.Move(Right)
+				if _, err := svc.DropCount(2); err == nil {
+					t.Fatalf("expected blue on red to be rejected")
+				}
+				if state := svc.GetState(); len(state.Robots[0].Holding) != 2 || len(state.Grid[1][0]) != 1 {
+					t.Fatalf("expected a rejected drop to change nothing, got %+v", state)
 				}
 			},
 		},
 		{
-			name: "history after pick, move and drop",
-			setupFunc: func(svc *Service) {
+			name:     "drop more than carried",
+			capacity: 3,
+			validateFunc: func(t *testing.T, svc *Service) {
 				svc.Pick()
-				svc.Move(Down)
-				svc.Drop()
+				_, err := svc.DropCount(2)
+				if err == nil || err.Error() != "only holding 1 circles" {
+					t.Fatalf("expected carried count error, got %v", err)
+				}
 			},
-			validateFunc: func(t *testing.T, history []MovementHistory) {
-				expected := []string{
-					"Picked up a red circle",
-					"Moved down",
-					"Dropped a red circle",
+		},
+		{
+			name:     "solver moves a stack in one go",
+			capacity: 2,
+			setupFunc: func(ds *DataStore) {
+				for x := range 3 {
+					ds.State.Grid[x] = [][]Circle{{}, {}, {}}
 				}
-				for i, exp := range expected {
-					if history[i].Moves != exp {
-						t.Fatalf("expected '%s' at index %d, got '%s'", exp, i, history[i].Moves)
-					}
+				ds.State.Grid[1][0] = []Circle{Blue, Red}
+				ds.State.Robots[0].PositionX = 1
+			},
+			validateFunc: func(t *testing.T, svc *Service) {
+				commands, err := svc.Solve(DefaultSolverLimits)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(commands) != 3 || commands[0].Count != 2 {
+					t.Fatalf("expected pick 2, move, drop 2, got %+v", commands)
 				}
 			},
 		},
@@ -507,14 +876,1146 @@ func TestService_GetHistory(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ds := NewDataStore()
-			svc := NewService(ds)
+			ds := NewDataStore(DefaultGridSize, DefaultGridSize)
+			ds.State.Grid[0][0] = []Circle{Green, Blue, Red}
+			ds.State.Grid[1][0] = []Circle{}
+			if tt.setupFunc != nil {
+				tt.setupFunc(ds)
+			}
 
-			tt.setupFunc(svc)
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
+			svc.capacity = tt.capacity
 
-			history := svc.GetHistory()
+			tt.validateFunc(t, svc)
+		})
+	}
+}
 
-			tt.validateFunc(t, history)
+func TestService_MovementModels(t *testing.T) {
+	tests := []struct {
+		name         string
+		movement     MovementModel
+		validateFunc func(*testing.T, *Service)
+	}{
+		{
+			name:     "four neighbour rejects diagonals",
+			movement: FourNeighbour,
+			validateFunc: func(t *testing.T, svc *Service) {
+				_, err := svc.Move(DownRight)
+				if err == nil || err.Error() != "cannot move down_right with four_neighbour movement" {
+					t.Fatalf("expected diagonal to be rejected, got %v", err)
+				}
+			},
+		},
+		{
+			name:     "eight neighbour moves diagonally",
+			movement: EightNeighbour,
+			validateFunc: func(t *testing.T, svc *Service) {
+				state, err := svc.Move(DownRight)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if state.Robots[0].PositionX != 1 || state.Robots[0].PositionY != 1 {
+					t.Fatalf("expected robot at (1,1), got (%d,%d)", state.Robots[0].PositionX, state.Robots[0].PositionY)
+				}
+			},
+		},
+		{
+			name:     "move to a cell",
+			movement: FourNeighbour,
+			validateFunc: func(t *testing.T, svc *Service) {
+				state, err := svc.MoveTo(2, 1)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if state.Robots[0].PositionX != 2 || state.Robots[0].PositionY != 1 {
+					t.Fatalf("expected robot at (2,1), got (%d,%d)", state.Robots[0].PositionX, state.Robots[0].PositionY)
+				}
+				if got := svc.GetHistory()[0].Description(); got != "Moved to (2,1)" {
+					t.Fatalf("unexpected description %q", got)
+				}
+			},
+		},
+		{
+			name:     "move to a cell outside the grid",
+			movement: FourNeighbour,
+			validateFunc: func(t *testing.T, svc *Service) {
+				if _, err := svc.MoveTo(3, 0); err == nil {
+					t.Fatalf("expected out of bounds error")
+				}
+			},
+		},
+		{
+			name:     "path finding goes around robots",
+			movement: FourNeighbour,
+			validateFunc: func(t *testing.T, svc *Service) {
+				svc.storage.State.Robots = append(svc.storage.State.Robots, Robot{ID: "2", PositionX: 1})
+				if _, err := svc.MoveTo(2, 0); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name:     "no path through a wall of robots",
+			movement: EightNeighbour,
+			validateFunc: func(t *testing.T, svc *Service) {
+				for y := range 3 {
+					svc.storage.State.Robots = append(svc.storage.State.Robots, Robot{ID: robotID(y + 1), PositionX: 1, PositionY: y})
+				}
+				_, err := svc.MoveTo(2, 0)
+				if err == nil || err.Error() != "no free path to cell (2,0)" {
+					t.Fatalf("expected no path error, got %v", err)
+				}
+			},
+		},
+		{
+			name:     "teleport jumps over robots",
+			movement: Teleport,
+			validateFunc: func(t *testing.T, svc *Service) {
+				for y := range 3 {
+					svc.storage.State.Robots = append(svc.storage.State.Robots, Robot{ID: robotID(y + 1), PositionX: 1, PositionY: y})
+				}
+				if _, err := svc.MoveTo(2, 0); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				_, err := svc.MoveTo(1, 1)
+				if err == nil || err.Error() != "cell (1,1) is occupied by robot 3" {
+					t.Fatalf("expected occupied error, got %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDataStore(DefaultGridSize, DefaultGridSize)
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
+			svc.movement = tt.movement
+
+			tt.validateFunc(t, svc)
+		})
+	}
+}
+
+func TestService_CellTypes(t *testing.T) {
+	tests := []struct {
+		name         string
+		setupFunc    func(*DataStore)
+		validateFunc func(*testing.T, *Service)
+	}{
+		{
+			name: "walls cannot be entered",
+			setupFunc: func(ds *DataStore) {
+				ds.State.Cells[1][0] = Wall
+			},
+			validateFunc: func(t *testing.T, svc *Service) {
+				_, err := svc.Move(Right)
+				if err == nil || err.Error() != "cell (1,0) is a wall" {
+					t.Fatalf("expected wall error, got %v", err)
+				}
+			},
+		},
+		{
+			name: "path finding goes around walls",
+			setupFunc: func(ds *DataStore) {
+				ds.State.Cells[1][0] = Wall
+				ds.State.Cells[1][1] = Wall
+			},
+			validateFunc: func(t *testing.T, svc *Service) {
+				if _, err := svc.MoveTo(2, 0); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if _, err := svc.MoveTo(1, 1); err == nil {
+					t.Fatalf("expected moving into a wall to fail")
+				}
+			},
+		},
+		{
+			name: "no-drop zone refuses drops",
+			setupFunc: func(ds *DataStore) {
+				ds.State.Cells[0][0] = NoDrop
+			},
+			validateFunc: func(t *testing.T, svc *Service) {
+				if _, err := svc.Pick(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				_, err := svc.Drop()
+				if err == nil || err.Error() != "cannot drop circles in a no-drop zone" {
+					t.Fatalf("expected no-drop error, got %v", err)
+				}
+			},
+		},
+		{
+			name: "drop-only zone keeps its circles",
+			setupFunc: func(ds *DataStore) {
+				ds.State.Cells[0][0] = DropOnly
+				ds.State.Grid[0][0] = []Circle{}
+				ds.State.Robots[0].Holding = []Circle{Red}
+			},
+			validateFunc: func(t *testing.T, svc *Service) {
+				if _, err := svc.Drop(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				_, err := svc.Pick()
+				if err == nil || err.Error() != "cannot pick up circles from a drop-only zone" {
+					t.Fatalf("expected drop-only error, got %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDataStore(DefaultGridSize, DefaultGridSize)
+			ds.State.Cells = [][]CellType{{Floor, Floor, Floor}, {Floor, Floor, Floor}, {Floor, Floor, Floor}}
+			tt.setupFunc(ds)
+
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
+
+			tt.validateFunc(t, svc)
+		})
+	}
+}
+
+func TestService_StackLimits(t *testing.T) {
+	tests := []struct {
+		name         string
+		setupFunc    func(*DataStore)
+		count        int
+		errorMessage string
+	}{
+		{
+			name: "drop within the global limit",
+			setupFunc: func(ds *DataStore) {
+				ds.State.StackLimit = 2
+			},
+			count: 1,
+		},
+		{
+			name: "drop onto a full cell",
+			setupFunc: func(ds *DataStore) {
+				ds.State.StackLimit = 1
+			},
+			count:        1,
+			errorMessage: "cell (1,0) can only hold 1 circles",
+		},
+		{
+			name: "dropping several circles must fit them all",
+			setupFunc: func(ds *DataStore) {
+				ds.State.StackLimit = 2
+				ds.State.Robots[0].Holding = []Circle{Green, Red}
+			},
+			count:        2,
+			errorMessage: "cell (1,0) can only hold 2 circles",
+		},
+		{
+			name: "a cell limit overrides the global one",
+			setupFunc: func(ds *DataStore) {
+				ds.State.StackLimit = 1
+				ds.State.StackLimits = [][]int{{0, 0, 0}, {3, 0, 0}, {0, 0, 0}}
+			},
+			count: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDataStore(DefaultGridSize, DefaultGridSize)
+			ds.State.Grid[1][0] = []Circle{Green}
+			ds.State.Robots[0].PositionX = 1
+			ds.State.Robots[0].Holding = []Circle{Red}
+			tt.setupFunc(ds)
+
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
+			svc.capacity = 2
+
+			_, err := svc.DropCount(tt.count)
+
+			if tt.errorMessage != "" {
+				if err == nil || err.Error() != tt.errorMessage {
+					t.Fatalf("expected error '%s', got '%v'", tt.errorMessage, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestService_Energy(t *testing.T) {
+	x, y := 2, 1
+
+	tests := []struct {
+		name           string
+		energy         int
+		commands       []CommandRequest
+		expectedUsed   int
+		expectedEnergy []int
+		errorMessage   string
+	}{
+		{
+			name:           "moving loaded costs more than moving empty",
+			commands:       []CommandRequest{{Action: Move, Direction: Right}, {Action: PickUp}, {Action: Move, Direction: Left}},
+			expectedUsed:   6,
+			expectedEnergy: []int{1, 2, 3},
+		},
+		{
+			name:           "move_to pays for every step",
+			commands:       []CommandRequest{{Action: MoveTo, X: &x, Y: &y}},
+			expectedUsed:   3,
+			expectedEnergy: []int{3},
+		},
+		{
+			name:           "commands are refused once the budget runs out",
+			energy:         4,
+			commands:       []CommandRequest{{Action: PickUp}, {Action: Move, Direction: Right}},
+			expectedUsed:   2,
+			expectedEnergy: []int{2, 0},
+			errorMessage:   "not enough energy: the command needs 3 but only 2 is left",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := NewService(NewDataStore(DefaultGridSize, DefaultGridSize), ClassicRule{}, LastColumnCondition{})
+			svc.costs = ActionCosts{MoveEmpty: 1, MoveLoaded: 3, Pick: 2, Drop: 2}
+			svc.energy = tt.energy
+
+			var err error
+			for _, cmd := range tt.commands {
+				if _, err = svc.Execute(cmd); err != nil {
+					break
+				}
+			}
+
+			if tt.errorMessage != "" {
+				if err == nil || err.Error() != tt.errorMessage {
+					t.Fatalf("expected error '%s', got '%v'", tt.errorMessage, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if used := svc.GetState().EnergyUsed; used != tt.expectedUsed {
+				t.Fatalf("expected %d energy used, got %d", tt.expectedUsed, used)
+			}
+			for i, entry := range svc.GetHistory() {
+				if entry.Energy != tt.expectedEnergy[i] {
+					t.Fatalf("expected entry %d to cost %d, got %d", i, tt.expectedEnergy[i], entry.Energy)
+				}
+			}
+		})
+	}
+}
+
+func TestService_Score(t *testing.T) {
+	tests := []struct {
+		name          string
+		directions    []Direction
+		expectedScore Score
+	}{
+		{
+			name:          "optimal play",
+			directions:    []Direction{Right},
+			expectedScore: Score{EnergyUsed: 5, Optimal: 5, Efficiency: 1, Points: 1000},
+		},
+		{
+			name:          "detour",
+			directions:    []Direction{Right, Left, Right},
+			expectedScore: Score{EnergyUsed: 11, Optimal: 5, Efficiency: 5.0 / 11, Points: 455},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			board := defaultBoard(2, 1, 1)
+			board.Grid[1][0] = []Circle{}
+
+			svc := NewService(newDataStore(board), ClassicRule{}, LastColumnCondition{})
+			svc.costs = ActionCosts{MoveEmpty: 1, MoveLoaded: 3, Pick: 1, Drop: 1}
+
+			if _, err := svc.Pick(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if score := svc.Score(svc.GetState()); score != nil {
+				t.Fatalf("expected no score before winning, got %+v", score)
+			}
+			for _, direction := range tt.directions {
+				if _, err := svc.Move(direction); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if _, err := svc.Drop(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			<-svc.optimal.done
+
+			score := svc.Score(svc.GetState())
+			if score == nil || *score != tt.expectedScore {
+				t.Fatalf("expected score %+v, got %+v", tt.expectedScore, score)
+			}
+
+			history := svc.GetHistory()
+			if last := history[len(history)-1]; last.Score != tt.expectedScore.Points {
+				t.Fatalf("expected the winning command to record %d points, got %d", tt.expectedScore.Points, last.Score)
+			}
+		})
+	}
+}
+
+func TestService_PendingScore(t *testing.T) {
+	board := defaultBoard(2, 1, 1)
+	board.Grid[1][0] = []Circle{}
+
+	svc := NewService(newDataStore(board), ClassicRule{}, LastColumnCondition{})
+	svc.store = NewMemoryStore()
+	svc.optimal = &optimum{done: make(chan struct{})}
+
+	svc.Pick()
+	svc.Move(Right)
+	if _, err := svc.Drop(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if score := svc.Score(svc.GetState()); score == nil || !score.Pending || score.Points != 0 {
+		t.Fatalf("expected a pending score while the optimum is unknown, got %+v", score)
+	}
+	if entries, _ := svc.store.LoadLeaderboard(""); len(entries) != 0 {
+		t.Fatalf("expected no leaderboard entry before the win is scored, got %+v", entries)
+	}
+
+	svc.storage.Mu.Lock()
+	svc.optimal.energy, svc.optimal.solved = 3, true
+	svc.rank()
+	svc.storage.Mu.Unlock()
+
+	entries, _ := svc.store.LoadLeaderboard("")
+	if len(entries) != 1 || entries[0].Score == nil || *entries[0].Score != MaxScore {
+		t.Fatalf("expected the win to be ranked once scored, got %+v", entries)
+	}
+	history := svc.GetHistory()
+	if last := history[len(history)-1]; last.Score != MaxScore {
+		t.Fatalf("expected the winning command to record %d points, got %d", MaxScore, last.Score)
+	}
+}
+
+func TestService_ScoreLargeBoard(t *testing.T) {
+	svc := NewService(NewDataStore(6, 6), ClassicRule{}, LastColumnCondition{})
+
+	svc.storage.Mu.Lock()
+	svc.findOptimal()
+	optimal, ok := svc.optimal.result()
+	svc.storage.Mu.Unlock()
+
+	if !ok || optimal != -1 {
+		t.Fatalf("expected a large board to go unscored without a search, got %d, %v", optimal, ok)
+	}
+}
+
+func TestService_Leaderboard(t *testing.T) {
+	win := []CommandRequest{{Action: PickUp}, {Action: Move, Direction: Right}, {Action: Drop}}
+
+	tests := []struct {
+		name         string
+		playFunc     func(*testing.T, *Service)
+		expectWon    bool
+		expectRanked bool
+	}{
+		{
+			name: "a win is ranked on the board's leaderboard",
+			playFunc: func(t *testing.T, svc *Service) {
+				if _, err := svc.ExecuteBatch(win); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			},
+			expectWon:    true,
+			expectRanked: true,
+		},
+		{
+			name: "a rolled back batch does not finish the game",
+			playFunc: func(t *testing.T, svc *Service) {
+				if _, err := svc.ExecuteBatch(append(win, CommandRequest{Action: Drop})); err == nil {
+					t.Fatalf("expected the batch to fail")
+				}
+			},
+		},
+		{
+			name: "a replayed win is not ranked",
+			playFunc: func(t *testing.T, svc *Service) {
+				svc.ExecuteBatch(win)
+				history := svc.GetHistory()
+
+				svc.store = NewMemoryStore()
+				if result, _ := svc.Replay(history); result.Diverged {
+					t.Fatalf("unexpected divergence %+v", result)
+				}
+			},
+			expectWon: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			board := defaultBoard(2, 1, 1)
+			board.Grid[1][0] = []Circle{}
+
+			svc := NewService(newDataStore(board), ClassicRule{}, LastColumnCondition{})
+			svc.store = NewMemoryStore()
+			svc.optimal = solvedOptimum(3)
+
+			tt.playFunc(t, svc)
+
+			if won := !svc.Clock(time.Now()).FinishedAt.IsZero(); won != tt.expectWon {
+				t.Fatalf("expected finished to be %v, got %v", tt.expectWon, won)
+			}
+
+			entries, _ := svc.store.LoadLeaderboard("")
+			if !tt.expectRanked {
+				if len(entries) != 0 {
+					t.Fatalf("expected no leaderboard entry, got %+v", entries)
+				}
+				return
+			}
+			id := svc.LeaderboardID()
+			if !strings.HasPrefix(id, "2x1-") || len(entries) != 1 || entries[0].LayoutID != id {
+				t.Fatalf("expected one entry for %s, got %+v", id, entries)
+			}
+		})
+	}
+}
+
+func TestService_TimedMode(t *testing.T) {
+	// won clears every column but the last, which meets last_column.
+	won := func(svc *Service) {
+		for x := range svc.storage.State.Width - 1 {
+			for y := range svc.storage.State.Height {
+				svc.storage.State.Grid[x][y] = []Circle{}
+			}
+		}
+	}
+
+	tests := []struct {
+		name        string
+		setupFunc   func(*Service)
+		expectLost  bool
+		expectedErr error
+	}{
+		{
+			name:      "commands run before the deadline",
+			setupFunc: func(svc *Service) {},
+		},
+		{
+			name: "commands are refused after the deadline",
+			setupFunc: func(svc *Service) {
+				svc.storage.StartedAt = time.Now().Add(-2 * time.Minute)
+			},
+			expectLost:  true,
+			expectedErr: ErrTimeUp,
+		},
+		{
+			name: "a game won in time is never lost",
+			setupFunc: func(svc *Service) {
+				won(svc)
+				svc.storage.StartedAt = time.Now().Add(-2 * time.Minute)
+				svc.storage.FinishedAt = svc.storage.StartedAt.Add(30 * time.Second)
+			},
+		},
+		{
+			name: "undoing the win restarts the clock",
+			setupFunc: func(svc *Service) {
+				before := svc.storage.State.clone()
+				won(svc)
+				svc.storage.StartedAt = time.Now().Add(-2 * time.Minute)
+				svc.storage.FinishedAt = svc.storage.StartedAt.Add(30 * time.Second)
+				svc.storage.Done = []operation{{Before: before, After: svc.storage.State.clone()}}
+
+				if _, err := svc.Undo(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			},
+			expectLost:  true,
+			expectedErr: ErrTimeUp,
+		},
+		{
+			name: "reset restarts the clock",
+			setupFunc: func(svc *Service) {
+				svc.storage.StartedAt = time.Now().Add(-2 * time.Minute)
+				svc.Reset(nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := NewService(NewDataStore(DefaultGridSize, DefaultGridSize), ClassicRule{}, LastColumnCondition{})
+			svc.timeLimit = time.Minute
+			svc.optimal = solvedOptimum(0)
+			tt.setupFunc(svc)
+
+			_, err := svc.Move(Right)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error '%v', got '%v'", tt.expectedErr, err)
+			}
+
+			clock := svc.Clock(time.Now())
+			if clock.Lost != tt.expectLost {
+				t.Fatalf("expected lost to be %v, got %+v", tt.expectLost, clock)
+			}
+			if tt.expectLost {
+				if clock.Remaining != 0 {
+					t.Fatalf("expected no time remaining, got %v", clock.Remaining)
+				}
+				if _, err := svc.Undo(); !errors.Is(err, ErrTimeUp) {
+					t.Fatalf("expected undo to be refused, got %v", err)
+				}
+				history := svc.GetHistory()
+				if len(history) != 1 || history[0].Success || history[0].Error != ErrTimeUp.Error() {
+					t.Fatalf("expected the refused command in the history, got %+v", history)
+				}
+			}
+		})
+	}
+}
+
+func TestService_WinConditions(t *testing.T) {
+	emptyGrid := func(ds *DataStore) {
+		for x := range ds.State.Width {
+			for y := range ds.State.Height {
+				ds.State.Grid[x][y] = []Circle{}
+			}
+		}
+	}
+
+	tests := []struct {
+		name      string
+		config    WinConditionConfig
+		setupFunc func(*DataStore)
+		expectWon bool
+	}{
+		{
+			name:      "last column not met on the starting grid",
+			config:    WinConditionConfig{Name: "last_column"},
+			setupFunc: func(ds *DataStore) {},
+			expectWon: false,
+		},
+		{
+			name:   "last row is the old name of last column",
+			config: WinConditionConfig{Name: "last_row"},
+			setupFunc: func(ds *DataStore) {
+				emptyGrid(ds)
+				ds.State.Grid[2][0] = []Circle{Red}
+			},
+			expectWon: true,
+		},
+		{
+			name:   "target cell defaults to the bottom right corner",
+			config: WinConditionConfig{Name: "target_cell"},
+			setupFunc: func(ds *DataStore) {
+				emptyGrid(ds)
+				ds.State.Grid[2][2] = []Circle{Green, Blue, Red}
+			},
+			expectWon: true,
+		},
+		{
+			name:   "target cell not met while holding",
+			config: WinConditionConfig{Name: "target_cell"},
+			setupFunc: func(ds *DataStore) {
+				emptyGrid(ds)
+				ds.State.Robots[0].Holding = []Circle{Red}
+			},
+			expectWon: false,
+		},
+		{
+			name:   "sorted columns met with one colour per column",
+			config: WinConditionConfig{Name: "sorted_columns"},
+			setupFunc: func(ds *DataStore) {
+				emptyGrid(ds)
+				ds.State.Grid[0][0] = []Circle{Red, Red}
+				ds.State.Grid[0][2] = []Circle{Red}
+				ds.State.Grid[1][1] = []Circle{Green, Green, Green}
+				ds.State.Grid[2][0] = []Circle{Blue}
+			},
+			expectWon: true,
+		},
+		{
+			name:   "sorted columns not met with mixed column",
+			config: WinConditionConfig{Name: "sorted_columns"},
+			setupFunc: func(ds *DataStore) {
+				emptyGrid(ds)
+				ds.State.Grid[0][0] = []Circle{Red}
+				ds.State.Grid[0][1] = []Circle{Blue}
+			},
+			expectWon: false,
+		},
+		{
+			name: "target grid met on exact match",
+			config: WinConditionConfig{Name: "target_grid", Grid: [][][]Circle{
+				{{Red}, {Green}, {Green}},
+				{{Blue}, {Red}, {Blue}},
+				{{Green}, {Blue}, {Red}},
+			}},
+			setupFunc: func(ds *DataStore) {},
+			expectWon: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			win, err := NewWinCondition(tt.config, DefaultGridSize, DefaultGridSize)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			ds := NewDataStore(DefaultGridSize, DefaultGridSize)
+			tt.setupFunc(ds)
+
+			svc := NewService(ds, ClassicRule{}, win)
+
+			if won := svc.HasWon(); won != tt.expectWon {
+				t.Fatalf("expected won=%v, got %v", tt.expectWon, won)
+			}
+		})
+	}
+}
+
+func TestService_UndoRedo(t *testing.T) {
+	tests := []struct {
+		name         string
+		setupFunc    func(*testing.T, *Service)
+		validateFunc func(*testing.T, *Service)
+	}{
+		{
+			name:      "undo with no commands",
+			setupFunc: func(t *testing.T, svc *Service) {},
+			validateFunc: func(t *testing.T, svc *Service) {
+				if _, err := svc.Undo(); err == nil || err.Error() != "nothing to undo" {
+					t.Fatalf("expected 'nothing to undo', got '%v'", err)
+				}
+			},
+		},
+		{
+			name: "undo pick restores the stack and history",
+			setupFunc: func(t *testing.T, svc *Service) {
+				svc.Move(Right)
+				svc.Pick()
+			},
+			validateFunc: func(t *testing.T, svc *Service) {
+				state, err := svc.Undo()
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(state.Robots[0].Holding) > 0 {
+					t.Fatalf("expected robot to hold nothing after undo, got %v", state.Robots[0].top())
+				}
+				if len(state.Grid[1][0]) != 1 || state.Grid[1][0][0] != Blue {
+					t.Fatalf("expected blue circle back at (1,0), got %v", state.Grid[1][0])
+				}
+				history := svc.GetHistory()
+				if len(history) != 1 || history[0].Description() != "Moved right" {
+					t.Fatalf("expected only 'Moved right' in history, got %v", history)
+				}
+			},
+		},
+		{
+			name: "redo reapplies undone commands in order",
+			setupFunc: func(t *testing.T, svc *Service) {
+				svc.Pick()
+				svc.Move(Down)
+				svc.Undo()
+				svc.Undo()
+			},
+			validateFunc: func(t *testing.T, svc *Service) {
+				svc.Redo()
+				state, err := svc.Redo()
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if state.Robots[0].PositionY != 1 || len(state.Robots[0].Holding) == 0 || state.Robots[0].top() != Red {
+					t.Fatalf("expected robot at y=1 holding red, got y=%d holding %v",
+						state.Robots[0].PositionY, state.Robots[0].Holding)
+				}
+				if len(svc.GetHistory()) != 2 {
+					t.Fatalf("expected 2 history entries, got %d", len(svc.GetHistory()))
+				}
+			},
+		},
+		{
+			name: "new command clears redo",
+			setupFunc: func(t *testing.T, svc *Service) {
+				svc.Move(Right)
+				svc.Undo()
+				svc.Move(Down)
+			},
+			validateFunc: func(t *testing.T, svc *Service) {
+				if _, err := svc.Redo(); err == nil || err.Error() != "nothing to redo" {
+					t.Fatalf("expected 'nothing to redo', got '%v'", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDataStore(DefaultGridSize, DefaultGridSize)
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
+
+			tt.setupFunc(t, svc)
+
+			tt.validateFunc(t, svc)
+		})
+	}
+}
+
+func TestService_GetHistory(t *testing.T) {
+	tests := []struct {
+		name         string
+		setupFunc    func(*Service)
+		validateFunc func(*testing.T, []MovementHistory)
+	}{
+		{
+			name:      "empty history initially",
+			setupFunc: func(svc *Service) {},
+			validateFunc: func(t *testing.T, history []MovementHistory) {
+				if len(history) != 0 {
+					t.Fatalf("expected empty history, got length %d", len(history))
+				}
+			},
+		},
+		{
+			name: "history after one move",
+			setupFunc: func(svc *Service) {
+				svc.Move(Right)
+			},
+			validateFunc: func(t *testing.T, history []MovementHistory) {
+				if history[0].Description() != "Moved right" {
+					t.Fatalf("expected 'Moved right', got '%s'", history[0].Description())
+				}
+			},
+		},
+		{
+			name: "history after pick, move and drop",
+			setupFunc: func(svc *Service) {
+				svc.Pick()
+				svc.Move(Down)
+				svc.Drop()
+			},
+			validateFunc: func(t *testing.T, history []MovementHistory) {
+				expected := []string{
+					"Picked up a red circle",
+					"Moved down",
+					"Dropped a red circle",
+				}
+				for i, exp := range expected {
+					if history[i].Description() != exp {
+						t.Fatalf("expected '%s' at index %d, got '%s'", exp, i, history[i].Description())
+					}
+				}
+			},
+		},
+		{
+			name: "structured fields for a successful pick",
+			setupFunc: func(svc *Service) {
+				svc.Move(Right)
+				svc.Pick()
+			},
+			validateFunc: func(t *testing.T, history []MovementHistory) {
+				pick := history[1]
+				if pick.Sequence != 2 || pick.Action != PickUp || pick.Circle != Blue || !pick.Success {
+					t.Fatalf("unexpected pick entry %+v", pick)
+				}
+				if pick.FromX != 1 || pick.FromY != 0 || pick.ToX != 1 || pick.ToY != 0 {
+					t.Fatalf("expected pick at (1,0), got from (%d,%d) to (%d,%d)",
+						pick.FromX, pick.FromY, pick.ToX, pick.ToY)
+				}
+			},
+		},
+		{
+			name: "failed commands are recorded",
+			setupFunc: func(svc *Service) {
+				svc.Move(Up)
+				svc.Drop()
+			},
+			validateFunc: func(t *testing.T, history []MovementHistory) {
+				if len(history) != 2 {
+					t.Fatalf("expected 2 history entries, got %d", len(history))
+				}
+				if history[0].Success || history[0].Error != "cannot move further in that direction" {
+					t.Fatalf("expected failed move entry, got %+v", history[0])
+				}
+				expected := "Failed to drop a circle: not holding any circle to drop"
+				if history[1].Description() != expected {
+					t.Fatalf("expected '%s', got '%s'", expected, history[1].Description())
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDataStore(DefaultGridSize, DefaultGridSize)
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
+
+			tt.setupFunc(svc)
+
+			history := svc.GetHistory()
+
+			tt.validateFunc(t, history)
+		})
+	}
+}
+
+func TestService_Replay(t *testing.T) {
+	exportCSV := func(t *testing.T, history []MovementHistory) []byte {
+		var buf bytes.Buffer
+		writer := csv.NewWriter(&buf)
+		writer.Write(historyHeader)
+		for _, record := range history {
+			writer.Write(historyRow(record))
+		}
+		writer.Flush()
+		return buf.Bytes()
+	}
+
+	recordGame := func(t *testing.T) []MovementHistory {
+		svc := NewService(NewDataStore(DefaultGridSize, DefaultGridSize), ClassicRule{}, LastColumnCondition{})
+		svc.Pick()
+		svc.Move(Down)
+		svc.Move(Left)
+		svc.Drop()
+		return svc.GetHistory()
+	}
+
+	tests := []struct {
+		name         string
+		modifyFunc   func([]MovementHistory)
+		validateFunc func(*testing.T, ReplayResult, State)
+	}{
+		{
+			name:       "replay matches the recording",
+			modifyFunc: func(history []MovementHistory) {},
+			validateFunc: func(t *testing.T, result ReplayResult, state State) {
+				if result.Diverged || result.Steps != 4 {
+					t.Fatalf("expected clean replay of 4 steps, got %+v", result)
+				}
+				if len(state.Grid[0][1]) != 2 || state.Grid[0][1][1] != Red {
+					t.Fatalf("expected red circle dropped on (0,1), got %v", state.Grid[0][1])
+				}
+			},
+		},
+		{
+			name: "replay reports a diverging position",
+			modifyFunc: func(history []MovementHistory) {
+				history[1].ToY = 2
+			},
+			validateFunc: func(t *testing.T, result ReplayResult, state State) {
+				if !result.Diverged || result.Step != 2 {
+					t.Fatalf("expected divergence at step 2, got %+v", result)
+				}
+				if result.Reason != "robot ended at (0,1), expected (0,2)" {
+					t.Fatalf("unexpected reason '%s'", result.Reason)
+				}
+			},
+		},
+		{
+			name: "replay reports a recorded success that now fails",
+			modifyFunc: func(history []MovementHistory) {
+				history[0].Action = Drop
+			},
+			validateFunc: func(t *testing.T, result ReplayResult, state State) {
+				if !result.Diverged || result.Step != 1 {
+					t.Fatalf("expected divergence at step 1, got %+v", result)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history := recordGame(t)
+			tt.modifyFunc(history)
+
+			parsed, err := ParseHistoryCSV(bytes.NewReader(exportCSV(t, history)))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			svc := NewService(NewDataStore(DefaultGridSize, DefaultGridSize), ClassicRule{}, LastColumnCondition{})
+			svc.store = NewMemoryStore()
+			svc.Move(Right)
+
+			events, unsubscribe := svc.Subscribe()
+			defer unsubscribe()
+
+			result, state := svc.Replay(parsed)
+
+			if event := <-events; event.Command.Action != Reset || event.State.Robots[0].PositionX != 0 {
+				t.Fatalf("expected the restart to be broadcast first, got %+v", event)
+			}
+			if games, _ := svc.Archive(); len(games) != 1 || games[0].Final.Robots[0].PositionX != 1 {
+				t.Fatalf("expected the replaced game to be archived, got %+v", games)
+			}
+
+			tt.validateFunc(t, result, state)
+		})
+	}
+}
+
+func TestService_ExecuteBatch(t *testing.T) {
+	tests := []struct {
+		name          string
+		commands      []CommandRequest
+		expectedIndex int
+		expectError   bool
+		validateFunc  func(*testing.T, *Service)
+	}{
+		{
+			name: "all commands succeed",
+			commands: []CommandRequest{
+				{Action: PickUp},
+				{Action: Move, Direction: Down},
+				{Action: Drop},
+			},
+			validateFunc: func(t *testing.T, svc *Service) {
+				state := svc.GetState()
+				if len(state.Grid[0][1]) != 2 || state.Grid[0][1][1] != Red {
+					t.Fatalf("expected red circle on (0,1), got %v", state.Grid[0][1])
+				}
+				if len(svc.GetHistory()) != 3 {
+					t.Fatalf("expected 3 history entries, got %d", len(svc.GetHistory()))
+				}
+			},
+		},
+		{
+			name: "stacking rule failure rolls back everything",
+			commands: []CommandRequest{
+				{Action: Move, Direction: Right},
+				{Action: PickUp},
+				{Action: Move, Direction: Down},
+				{Action: Drop},
+			},
+			expectedIndex: 3,
+			expectError:   true,
+			validateFunc: func(t *testing.T, svc *Service) {
+				state := svc.GetState()
+				if state.Robots[0].PositionX != 0 || state.Robots[0].PositionY != 0 || len(state.Robots[0].Holding) > 0 {
+					t.Fatalf("expected robot back at (0,0) holding nothing, got %+v", state.Robots[0])
+				}
+				if len(state.Grid[1][0]) != 1 {
+					t.Fatalf("expected blue circle restored at (1,0), got %v", state.Grid[1][0])
+				}
+				if len(svc.GetHistory()) != 0 {
+					t.Fatalf("expected empty history after rollback, got %d entries", len(svc.GetHistory()))
+				}
+			},
+		},
+		{
+			name: "out of bounds failure reports its index",
+			commands: []CommandRequest{
+				{Action: Move, Direction: Down},
+				{Action: Move, Direction: Left},
+			},
+			expectedIndex: 1,
+			expectError:   true,
+			validateFunc:  func(t *testing.T, svc *Service) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDataStore(DefaultGridSize, DefaultGridSize)
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
+
+			_, err := svc.ExecuteBatch(tt.commands)
+
+			if tt.expectError {
+				var batchErr *BatchError
+				if !errors.As(err, &batchErr) || batchErr.Index != tt.expectedIndex {
+					t.Fatalf("expected batch error at index %d, got '%v'", tt.expectedIndex, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			tt.validateFunc(t, svc)
+		})
+	}
+}
+
+func TestService_Subscribe(t *testing.T) {
+	tests := []struct {
+		name           string
+		actions        func(*Service)
+		expectedEvents []CommandRequest
+	}{
+		{
+			name: "successful commands are published",
+			actions: func(svc *Service) {
+				svc.Move(Right)
+				svc.Pick()
+			},
+			expectedEvents: []CommandRequest{
+				{Action: Move, Direction: Right},
+				{Action: PickUp},
+			},
+		},
+		{
+			name: "failed commands are not published",
+			actions: func(svc *Service) {
+				svc.Move(Up)
+				svc.Drop()
+			},
+			expectedEvents: nil,
+		},
+		{
+			name: "rolled back batches are not published",
+			actions: func(svc *Service) {
+				svc.ExecuteBatch([]CommandRequest{
+					{Action: Move, Direction: Down},
+					{Action: Move, Direction: Left},
+				})
+			},
+			expectedEvents: nil,
+		},
+		{
+			name: "undo and redo are published",
+			actions: func(svc *Service) {
+				svc.Move(Down)
+				svc.Undo()
+				svc.Redo()
+			},
+			expectedEvents: []CommandRequest{
+				{Action: Move, Direction: Down},
+				{Action: Undo},
+				{Action: Redo},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDataStore(DefaultGridSize, DefaultGridSize)
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
+
+			events, cancel := svc.Subscribe()
+
+			tt.actions(svc)
+			cancel()
+
+			var received []CommandRequest
+			for event := range events {
+				received = append(received, event.Command)
+			}
+
+			if len(received) != len(tt.expectedEvents) {
+				t.Fatalf("expected %d events, got %v", len(tt.expectedEvents), received)
+			}
+			for i := range received {
+				if received[i] != tt.expectedEvents[i] {
+					t.Fatalf("expected event %v at index %d, got %v", tt.expectedEvents[i], i, received[i])
+				}
+			}
 		})
 	}
 }
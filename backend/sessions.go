@@ -0,0 +1,322 @@
+package main
+
+import (
+	"cmp"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const MaxGridSize = 20
+
+// GameConfig describes a game. Robots is how many robots a board without a
+// layout starts with; a layout places its own. Capacity is how many circles
+// each robot can carry at once. Palette registers the circle types the game
+// uses, the default red, green and blue if left out; a board without a layout
+// is tiled with its types. StackLimit caps every stack on boards whose layout
+// sets no limit of its own. Costs prices each command in energy, one unit each
+// if left out, and a non-zero Energy is the budget after which commands are
+// refused. Player names whoever plays the game on the leaderboard. A non-zero
+// TimeLimit, in seconds, makes the game timed: once it runs out unwon, the
+// game is lost and refuses commands.
+type GameConfig struct {
+	Width      int                `json:"width"`
+	Height     int                `json:"height"`
+	Robots     int                `json:"robots,omitempty"`
+	Capacity   int                `json:"capacity,omitempty"`
+	Movement   string             `json:"movement,omitempty"`
+	Rules      string             `json:"rules"`
+	Palette    Palette            `json:"palette,omitempty"`
+	StackLimit int                `json:"stack_limit,omitempty"`
+	Costs      *ActionCosts       `json:"costs,omitempty"`
+	Energy     int                `json:"energy,omitempty"`
+	Player     string             `json:"player,omitempty"`
+	TimeLimit  int                `json:"time_limit,omitempty"`
+	Win        WinConditionConfig `json:"win"`
+	Layout     *Layout            `json:"layout,omitempty"`
+}
+
+// withDefaults fills every unset field of c from defaults. A layout, given
+// or inherited, decides the grid size.
+func (c GameConfig) withDefaults(defaults GameConfig) GameConfig {
+	if c.Layout == nil && c.Width == 0 && c.Height == 0 {
+		c.Layout = defaults.Layout
+	}
+	if c.Layout != nil {
+		c.Width, c.Height = c.Layout.Width, c.Layout.Height
+	}
+	if c.Width == 0 {
+		c.Width = defaults.Width
+	}
+	if c.Height == 0 {
+		c.Height = defaults.Height
+	}
+	if c.Robots == 0 {
+		c.Robots = max(defaults.Robots, 1)
+	}
+	if c.Capacity == 0 {
+		c.Capacity = max(defaults.Capacity, 1)
+	}
+	if c.Movement == "" {
+		c.Movement = cmp.Or(defaults.Movement, string(DefaultMovementModel))
+	}
+	if c.Rules == "" {
+		c.Rules = defaults.Rules
+	}
+	if len(c.Palette) == 0 {
+		c.Palette = defaults.Palette
+	}
+	if c.StackLimit == 0 {
+		c.StackLimit = defaults.StackLimit
+	}
+	if c.Costs == nil {
+		c.Costs = defaults.Costs
+	}
+	if c.Energy == 0 {
+		c.Energy = defaults.Energy
+	}
+	if c.TimeLimit == 0 {
+		c.TimeLimit = defaults.TimeLimit
+	}
+	if c.Win.Name == "" {
+		c.Win = defaults.Win
+	}
+	return c
+}
+
+func NewGame(cfg GameConfig) (*Service, error) {
+	var state State
+	if cfg.Layout != nil {
+		state = cfg.Layout.State()
+	} else {
+		state = defaultBoard(cfg.Width, cfg.Height, cfg.Robots)
+		state.recolour(cfg.Palette)
+	}
+	state.StackLimit = cmp.Or(state.StackLimit, cfg.StackLimit)
+	return newGame(cfg, newDataStore(state))
+}
+
+// RestoreGame rebuilds a game saved by a Store.
+func RestoreGame(record GameRecord) (*Service, error) {
+	if err := validateRobots(record.State.Robots, record.State.Width, record.State.Height); err != nil {
+		return nil, err
+	}
+
+	svc, err := newGame(record.Config, &DataStore{
+		Initial:    record.Initial,
+		State:      record.State,
+		History:    record.History,
+		Sequence:   record.Sequence,
+		Done:       record.Done,
+		Undone:     record.Undone,
+		StartedAt:  cmp.Or(record.StartedAt, time.Now()),
+		FinishedAt: record.FinishedAt,
+		Ranked:     record.Ranked || !record.FinishedAt.IsZero(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	svc.id = record.ID
+	if record.Optimal != nil {
+		svc.optimal = solvedOptimum(*record.Optimal)
+	}
+	return svc, nil
+}
+
+func newGame(cfg GameConfig, storage *DataStore) (*Service, error) {
+	if cfg.Width < 1 || cfg.Height < 1 || cfg.Width > MaxGridSize || cfg.Height > MaxGridSize {
+		return nil, fmt.Errorf("invalid grid size %dx%d", cfg.Width, cfg.Height)
+	}
+
+	if cfg.Capacity < 1 {
+		return nil, fmt.Errorf("invalid carrying capacity %d", cfg.Capacity)
+	}
+
+	if cfg.StackLimit < 0 {
+		return nil, fmt.Errorf("invalid stack limit %d", cfg.StackLimit)
+	}
+
+	costs := DefaultActionCosts
+	if cfg.Costs != nil {
+		costs = *cfg.Costs
+	}
+	if err := costs.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Energy < 0 {
+		return nil, fmt.Errorf("invalid energy budget %d", cfg.Energy)
+	}
+	if cfg.TimeLimit < 0 {
+		return nil, fmt.Errorf("invalid time limit %d", cfg.TimeLimit)
+	}
+
+	movement, err := ParseMovementModel(cfg.Movement)
+	if err != nil {
+		return nil, err
+	}
+
+	palette := cfg.Palette
+	if len(palette) == 0 {
+		palette = DefaultPalette
+	}
+	if err := palette.Validate(); err != nil {
+		return nil, err
+	}
+
+	rules, err := LookupRuleSet(cfg.Rules, palette)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Layout != nil {
+		if err := cfg.Layout.Validate(rules, palette, cfg.Capacity); err != nil {
+			return nil, fmt.Errorf("invalid layout: %w", err)
+		}
+	} else if cfg.Robots < 1 || cfg.Robots > cfg.Width*cfg.Height {
+		return nil, fmt.Errorf("cannot fit %d robots on a %dx%d grid", cfg.Robots, cfg.Width, cfg.Height)
+	}
+
+	win, err := NewWinCondition(cfg.Win, cfg.Width, cfg.Height)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := storage.State.overfull(); err != nil {
+		return nil, err
+	}
+	if err := checkRoom(win, &storage.State); err != nil {
+		return nil, err
+	}
+
+	svc := NewService(storage, rules, win)
+	svc.capacity = cfg.Capacity
+	svc.movement = movement
+	svc.palette = palette
+	svc.costs = costs
+	svc.energy = cfg.Energy
+	svc.timeLimit = time.Duration(cfg.TimeLimit) * time.Second
+	svc.config = cfg
+	return svc, nil
+}
+
+type Session struct {
+	ID       string
+	Service  *Service
+	lastSeen time.Time
+}
+
+type SessionManager struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+	defaults GameConfig
+	ttl      time.Duration
+	store    Store
+}
+
+func NewSessionManager(defaults GameConfig, ttl time.Duration, store Store) *SessionManager {
+	return &SessionManager{
+		sessions: map[string]*Session{},
+		defaults: defaults,
+		ttl:      ttl,
+		store:    store,
+	}
+}
+
+// Recover loads every game saved in the store as a session, returning how
+// many were restored.
+func (m *SessionManager) Recover() (int, error) {
+	records, err := m.store.LoadGames()
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	restored := 0
+	for _, record := range records {
+		service, err := RestoreGame(record)
+		if err != nil {
+			log.Printf("skipping saved game %s: %v", record.ID, err)
+			continue
+		}
+		service.store = m.store
+
+		m.sessions[record.ID] = &Session{
+			ID:       record.ID,
+			Service:  service,
+			lastSeen: time.Now(),
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+func (m *SessionManager) Create(cfg GameConfig) (*Session, error) {
+	service, err := NewGame(cfg.withDefaults(m.defaults))
+	if err != nil {
+		return nil, err
+	}
+
+	session := &Session{
+		ID:       rand.Text(),
+		Service:  service,
+		lastSeen: time.Now(),
+	}
+
+	service.id = session.ID
+	service.store = m.store
+	if err := m.store.SaveGame(service.Snapshot()); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[session.ID] = session
+
+	return session, nil
+}
+
+// Get returns the session and marks it as recently used.
+func (m *SessionManager) Get(id string) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if ok {
+		session.lastSeen = time.Now()
+	}
+	return session, ok
+}
+
+// ExpireIdle removes every session not used since now minus the idle TTL and
+// returns how many were removed. Each game goes to the completed-games log
+// before its saved state is deleted.
+func (m *SessionManager) ExpireIdle(now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expired := 0
+	for id, session := range m.sessions {
+		if now.Sub(session.lastSeen) > m.ttl {
+			delete(m.sessions, id)
+			session.Service.Close()
+			if err := m.store.DeleteGame(id); err != nil {
+				log.Printf("deleting expired game %s: %v", id, err)
+			}
+			expired++
+		}
+	}
+	return expired
+}
+
+func (m *SessionManager) RunExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		m.ExpireIdle(now)
+	}
+}
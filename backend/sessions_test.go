@@ -0,0 +1,322 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func testDefaults() GameConfig {
+	return GameConfig{
+		Width:  DefaultGridSize,
+		Height: DefaultGridSize,
+		Rules:  DefaultRuleSet,
+		Win:    WinConditionConfig{Name: DefaultWinCondition},
+	}
+}
+
+func TestSessionManager_Create(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       GameConfig
+		validateFunc func(*testing.T, *Session)
+		expectError  bool
+	}{
+		{
+			name:   "empty config uses defaults",
+			config: GameConfig{},
+			validateFunc: func(t *testing.T, session *Session) {
+				state := session.Service.GetState()
+				if state.Width != DefaultGridSize || state.Height != DefaultGridSize {
+					t.Fatalf("expected %dx%d grid, got %dx%d",
+						DefaultGridSize, DefaultGridSize, state.Width, state.Height)
+				}
+			},
+		},
+		{
+			name:   "custom size and win condition",
+			config: GameConfig{Width: 5, Height: 4, Win: WinConditionConfig{Name: "target_cell"}},
+			validateFunc: func(t *testing.T, session *Session) {
+				state := session.Service.GetState()
+				if state.Width != 5 || state.Height != 4 {
+					t.Fatalf("expected 5x4 grid, got %dx%d", state.Width, state.Height)
+				}
+				if name := session.Service.WinCondition().Name(); name != "target_cell" {
+					t.Fatalf("expected target_cell win condition, got %s", name)
+				}
+			},
+		},
+		{
+			name: "custom palette with size ordered stacks",
+			config: GameConfig{
+				Rules: "hanoi",
+				Palette: Palette{
+					{Name: "small", Colour: "#fff", Size: 1},
+					{Name: "large", Colour: "#000", Size: 2},
+				},
+				Layout: &Layout{
+					Width:  2,
+					Height: 1,
+					Grid:   [][][]Circle{{{"large", "small"}}, {{}}},
+				},
+			},
+			validateFunc: func(t *testing.T, session *Session) {
+				svc := session.Service
+				if len(svc.Palette()) != 2 {
+					t.Fatalf("expected 2 circle types, got %+v", svc.Palette())
+				}
+				if _, err := svc.PickCount(1); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if _, err := svc.Move(Right); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if _, err := svc.Drop(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "custom palette without a layout",
+			config: GameConfig{
+				Rules: "hanoi",
+				Palette: Palette{
+					{Name: "small", Colour: "#fff", Size: 1},
+					{Name: "large", Colour: "#000", Size: 2},
+				},
+			},
+			validateFunc: func(t *testing.T, session *Session) {
+				svc := session.Service
+				for _, column := range svc.GetState().Grid {
+					for _, stack := range column {
+						if !svc.Palette().has(stack[0]) {
+							t.Fatalf("expected the board to use the palette, found %s", stack[0])
+						}
+					}
+				}
+				if _, err := svc.Pick(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "layout circle missing from the palette",
+			config: GameConfig{
+				Palette: Palette{{Name: "small", Colour: "#fff"}},
+				Layout:  &Layout{Width: 1, Height: 1, Grid: [][][]Circle{{{Red}}}},
+			},
+			expectError: true,
+		},
+		{
+			name:        "circle type registered twice",
+			config:      GameConfig{Palette: Palette{{Name: Red, Colour: "#f00"}, {Name: Red, Colour: "#f00"}}},
+			expectError: true,
+		},
+		{
+			name:   "global stack limit",
+			config: GameConfig{StackLimit: 3},
+			validateFunc: func(t *testing.T, session *Session) {
+				state := session.Service.GetState()
+				if limit := state.stackLimit(2, 2); limit != 3 {
+					t.Fatalf("expected a stack limit of 3, got %d", limit)
+				}
+			},
+		},
+		{
+			name:        "stack limit leaves no room for the goal",
+			config:      GameConfig{StackLimit: 2},
+			expectError: true,
+		},
+		{
+			name:        "unknown rule set",
+			config:      GameConfig{Rules: "nope"},
+			expectError: true,
+		},
+		{
+			name:        "grid too large",
+			config:      GameConfig{Width: MaxGridSize + 1},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewSessionManager(testDefaults(), time.Minute, NewMemoryStore())
+
+			session, err := m.Create(tt.config)
+
+			if tt.expectError {
+				if err == nil {
+					t.Fatalf("expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			tt.validateFunc(t, session)
+		})
+	}
+}
+
+func TestSessionManager_Isolation(t *testing.T) {
+	m := NewSessionManager(testDefaults(), time.Minute, NewMemoryStore())
+
+	first, _ := m.Create(GameConfig{})
+	second, _ := m.Create(GameConfig{})
+
+	if first.ID == second.ID {
+		t.Fatalf("expected distinct session IDs, got %s twice", first.ID)
+	}
+
+	if _, err := first.Service.Move(Right); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if x := second.Service.GetState().Robots[0].PositionX; x != 0 {
+		t.Fatalf("expected second session robot at x=0, got %d", x)
+	}
+}
+
+func TestSessionManager_ExpireIdle(t *testing.T) {
+	m := NewSessionManager(testDefaults(), time.Minute, NewMemoryStore())
+
+	idle, _ := m.Create(GameConfig{})
+	active, _ := m.Create(GameConfig{})
+
+	idle.Service.Move(Right)
+	idle.lastSeen = time.Now().Add(-2 * time.Minute)
+
+	if expired := m.ExpireIdle(time.Now()); expired != 1 {
+		t.Fatalf("expected 1 expired session, got %d", expired)
+	}
+
+	if games, _ := m.store.LoadArchive(idle.ID); len(games) != 1 || len(games[0].History) != 1 {
+		t.Fatalf("expected the idle game to be archived, got %+v", games)
+	}
+
+	if _, ok := m.Get(idle.ID); ok {
+		t.Fatalf("expected idle session to be removed")
+	}
+
+	if _, ok := m.Get(active.ID); !ok {
+		t.Fatalf("expected active session to be kept")
+	}
+}
+
+func TestSessionManager_Recover(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	before := NewSessionManager(testDefaults(), time.Minute, store)
+	session, _ := before.Create(GameConfig{Width: 4, Rules: "same_colour"})
+	session.Service.Pick()
+	session.Service.Move(Right)
+
+	after := NewSessionManager(testDefaults(), time.Minute, store)
+	restored, err := after.Recover()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if restored != 1 {
+		t.Fatalf("expected 1 restored session, got %d", restored)
+	}
+
+	recovered, ok := after.Get(session.ID)
+	if !ok {
+		t.Fatalf("expected session %s to be recovered", session.ID)
+	}
+
+	state := recovered.Service.GetState()
+	if state.Width != 4 || state.Robots[0].PositionX != 1 || len(state.Robots[0].Holding) == 0 || state.Robots[0].top() != Red {
+		t.Fatalf("unexpected recovered state %+v", state)
+	}
+	if history := recovered.Service.GetHistory(); len(history) != 2 {
+		t.Fatalf("expected 2 history entries, got %d", len(history))
+	}
+
+	if _, err := recovered.Service.Drop(); err == nil {
+		t.Fatalf("expected same_colour rules to survive recovery")
+	}
+
+	if state, err := recovered.Service.Undo(); err != nil || state.Robots[0].PositionX != 0 {
+		t.Fatalf("expected undo to survive recovery, got %+v, %v", state.Robots[0], err)
+	}
+}
+
+func TestSessionManager_Leaderboard(t *testing.T) {
+	m := NewSessionManager(testDefaults(), time.Minute, NewMemoryStore())
+
+	layout := &Layout{ID: "line", Width: 2, Height: 1, Grid: [][][]Circle{{{Red}}, {{}}}}
+	plays := []struct {
+		player     string
+		directions []Direction
+	}{
+		{player: "bob", directions: []Direction{Right, Left, Right}},
+		{player: "ada", directions: []Direction{Right}},
+	}
+
+	var id string
+	for _, play := range plays {
+		session, err := m.Create(GameConfig{Player: play.player, Layout: layout})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		svc := session.Service
+		if id == "" {
+			id = svc.LeaderboardID()
+		} else if svc.LeaderboardID() != id {
+			t.Fatalf("expected games of the same puzzle to share a leaderboard, got %s and %s", id, svc.LeaderboardID())
+		}
+
+		svc.Pick()
+		for _, direction := range play.directions {
+			svc.Move(direction)
+		}
+		if _, err := svc.Drop(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		<-svc.optimal.done
+
+		// Winning again after an undo must not add a second entry.
+		svc.Undo()
+		if _, err := svc.Redo(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	entries, err := m.Leaderboard(id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+
+	first, second := entries[0], entries[1]
+	if first.Player != "ada" || first.Rank != 1 || first.Moves != 3 || *first.Score != MaxScore {
+		t.Fatalf("unexpected first entry %+v", first)
+	}
+	if second.Player != "bob" || second.Rank != 2 || second.Moves != 5 || *second.Score >= *first.Score {
+		t.Fatalf("unexpected second entry %+v", second)
+	}
+
+	// A different board or setting under the same layout ID is another puzzle.
+	others := []GameConfig{
+		{Layout: &Layout{ID: "line", Width: 2, Height: 1, Grid: [][][]Circle{{{Green}}, {{}}}}},
+		{Layout: layout, Capacity: 2},
+		{Layout: layout, Movement: string(Teleport)},
+	}
+	for _, config := range others {
+		session, err := m.Create(config)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if other := session.Service.LeaderboardID(); other == id {
+			t.Fatalf("expected %+v to get its own leaderboard, got %s", config, other)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SolverLimits struct {
+	MaxNodes int
+	Timeout  time.Duration
+}
+
+var DefaultSolverLimits = SolverLimits{
+	MaxNodes: 500_000,
+	Timeout:  5 * time.Second,
+}
+
+// solverCells caps the cells held across every node of a search. Each node
+// keeps a whole board, so larger boards get fewer nodes than MaxNodes.
+const solverCells = 2_500_000
+
+// solverSlots lets a single search run at a time, as one search can take
+// much of the server's memory. Waiting for the slot counts against the
+// search's timeout.
+var solverSlots = make(chan struct{}, 1)
+
+var (
+	ErrUnsolvable  = errors.New("no sequence of commands reaches the win condition")
+	ErrSolverLimit = errors.New("solver gave up before finding a solution")
+	ErrAlreadyWon  = errors.New("the game is already won")
+)
+
+var solverCommands = []CommandRequest{
+	{Action: Move, Direction: Up},
+	{Action: Move, Direction: Down},
+	{Action: Move, Direction: Left},
+	{Action: Move, Direction: Right},
+	{Action: PickUp},
+	{Action: Drop},
+}
+
+// solverMoves lists every command any robot could be given in state: each
+// step the movement model allows, a jump to every cell worth teleporting to,
+// and every pick and drop count the carrying capacity allows. Robots are only
+// named when there is more than one.
+func (s *Service) solverMoves(state *State) []CommandRequest {
+	if len(state.Robots) == 1 && s.capacity == 1 && s.movement == FourNeighbour {
+		return solverCommands
+	}
+
+	var moves []CommandRequest
+	for _, robot := range state.Robots {
+		id := robot.ID
+		if len(state.Robots) == 1 {
+			id = ""
+		}
+
+		for _, direction := range s.movement.directions() {
+			moves = append(moves, CommandRequest{RobotID: id, Action: Move, Direction: direction})
+		}
+		if s.movement == Teleport {
+			for _, cell := range s.teleportTargets(state, &robot) {
+				x, y := cell[0], cell[1]
+				moves = append(moves, CommandRequest{RobotID: id, Action: MoveTo, X: &x, Y: &y})
+			}
+		}
+
+		moves = append(moves, CommandRequest{RobotID: id, Action: PickUp}, CommandRequest{RobotID: id, Action: Drop})
+		for count := 2; count <= s.capacity; count++ {
+			moves = append(moves,
+				CommandRequest{RobotID: id, Action: PickUp, Count: count},
+				CommandRequest{RobotID: id, Action: Drop, Count: count},
+			)
+		}
+	}
+	return moves
+}
+
+// teleportTargets lists the free cells robot could pick from or drop on.
+// Jumping anywhere else never shortens a solution, and leaving those cells out
+// keeps a large board from giving every state a child per cell.
+func (s *Service) teleportTargets(state *State, robot *Robot) [][2]int {
+	var targets [][2]int
+	for x := range state.Width {
+		for y := range state.Height {
+			if (x == robot.PositionX && y == robot.PositionY) || state.robotAt(x, y) != nil {
+				continue
+			}
+			if s.canPickAt(state, robot, x, y) || s.canDropAt(state, robot, x, y) {
+				targets = append(targets, [2]int{x, y})
+			}
+		}
+	}
+	return targets
+}
+
+func (s *Service) canPickAt(state *State, robot *Robot, x, y int) bool {
+	return len(robot.Holding) < s.capacity && len(state.Grid[x][y]) > 0 &&
+		state.cell(x, y) != Wall && state.cell(x, y) != DropOnly
+}
+
+// canDropAt reports whether the cell takes the bottom circle of any run of
+// circles robot could drop.
+func (s *Service) canDropAt(state *State, robot *Robot, x, y int) bool {
+	stack := state.Grid[x][y]
+	if cell := state.cell(x, y); cell == Wall || cell == NoDrop {
+		return false
+	}
+	if limit := state.stackLimit(x, y); limit > 0 && len(stack) >= limit {
+		return false
+	}
+	for _, circle := range robot.Holding {
+		if violatedRule(s.rules, stack, circle) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+type solverNode struct {
+	state    State
+	parent   int
+	cmd      CommandRequest
+	cost     int
+	priority int
+}
+
+// solverQueue orders node indices by cost so far plus the heuristic estimate.
+type solverQueue struct {
+	nodes []solverNode
+	items []int
+}
+
+func (q *solverQueue) Len() int { return len(q.items) }
+
+func (q *solverQueue) Less(i, j int) bool {
+	return q.nodes[q.items[i]].priority < q.nodes[q.items[j]].priority
+}
+
+func (q *solverQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+
+func (q *solverQueue) Push(x any) { q.items = append(q.items, x.(int)) }
+
+func (q *solverQueue) Pop() any {
+	last := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return last
+}
+
+// Solve returns the list of commands that takes the current state to the win
+// condition using the least energy. With the default costs that is also the
+// shortest list.
+func (s *Service) Solve(limits SolverLimits) ([]CommandRequest, error) {
+	s.storage.Mu.Lock()
+	start, win := s.storage.State.clone(), s.win
+	s.storage.Mu.Unlock()
+
+	return s.solve(start, win, limits)
+}
+
+// Hint returns the next command of a shortest solution and how many commands,
+// including that one, remain until the win condition is met.
+func (s *Service) Hint(limits SolverLimits) (CommandRequest, int, error) {
+	commands, err := s.Solve(limits)
+	if err != nil {
+		return CommandRequest{}, 0, err
+	}
+	if len(commands) == 0 {
+		return CommandRequest{}, 0, ErrAlreadyWon
+	}
+	return commands[0], len(commands), nil
+}
+
+// solve runs an A* search over states, expanding each one with the same apply
+// logic the live commands use and costing each by the energy it uses. Win
+// conditions that implement estimator guide the search; the others fall back
+// to a plain uniform-cost search.
+func (s *Service) solve(start State, win WinCondition, limits SolverLimits) ([]CommandRequest, error) {
+	// Stack limits can rule the goal out before any search.
+	if checkRoom(win, &start) != nil {
+		return nil, ErrUnsolvable
+	}
+
+	// Estimates count commands, so scale them by the cheapest command to
+	// keep them below the energy still needed.
+	estimate := func(*State) int { return 0 }
+	if e, ok := win.(estimator); ok {
+		m, cheapest := motion{capacity: s.capacity, movement: s.movement}, s.costs.cheapest()
+		estimate = func(state *State) int { return e.Estimate(state, m) * cheapest }
+	}
+
+	deadline := time.Now().Add(limits.Timeout)
+	select {
+	case solverSlots <- struct{}{}:
+		defer func() { <-solverSlots }()
+	case <-time.After(limits.Timeout):
+		return nil, ErrSolverLimit
+	}
+	maxNodes := min(limits.MaxNodes, max(1, solverCells/(start.Width*start.Height)))
+
+	queue := &solverQueue{
+		nodes: []solverNode{{state: start, parent: -1, priority: estimate(&start)}},
+		items: []int{0},
+	}
+	best := map[string]int{stateKey(&start): 0}
+
+	for queue.Len() > 0 {
+		if time.Now().After(deadline) {
+			return nil, ErrSolverLimit
+		}
+
+		current := heap.Pop(queue).(int)
+		node := queue.nodes[current]
+
+		if win.Met(&node.state) {
+			return solutionPath(queue.nodes, current), nil
+		}
+
+		for _, cmd := range s.solverMoves(&node.state) {
+			state := node.state.clone()
+			if err := s.apply(&state, cmd, &MovementHistory{}); err != nil {
+				continue
+			}
+
+			cost := node.cost + state.EnergyUsed - node.state.EnergyUsed
+			key := stateKey(&state)
+			if known, ok := best[key]; ok && known <= cost {
+				continue
+			}
+			best[key] = cost
+
+			queue.nodes = append(queue.nodes, solverNode{
+				state:    state,
+				parent:   current,
+				cmd:      cmd,
+				cost:     cost,
+				priority: cost + estimate(&state),
+			})
+			heap.Push(queue, len(queue.nodes)-1)
+			if len(queue.nodes) > maxNodes {
+				return nil, ErrSolverLimit
+			}
+		}
+
+		// Expanded states are only needed for their parent links from here on.
+		queue.nodes[current].state = State{}
+	}
+
+	return nil, ErrUnsolvable
+}
+
+func solutionPath(nodes []solverNode, last int) []CommandRequest {
+	var path []CommandRequest
+	for i := last; nodes[i].parent >= 0; i = nodes[i].parent {
+		path = append(path, nodes[i].cmd)
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+func stateKey(state *State) string {
+	var b strings.Builder
+	for _, robot := range state.Robots {
+		b.WriteString(strconv.Itoa(robot.PositionX))
+		b.WriteByte(',')
+		b.WriteString(strconv.Itoa(robot.PositionY))
+		b.WriteByte(':')
+		for _, circle := range robot.Holding {
+			b.WriteString(string(circle))
+			b.WriteByte(' ')
+		}
+		b.WriteByte(';')
+	}
+
+	for x := range state.Grid {
+		for y := range state.Grid[x] {
+			b.WriteByte('|')
+			for _, circle := range state.Grid[x][y] {
+				b.WriteString(string(circle))
+				b.WriteByte(' ')
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestService_Solve(t *testing.T) {
+	tests := []struct {
+		name          string
+		width         int
+		height        int
+		win           WinCondition
+		setupFunc     func(*DataStore)
+		limits        SolverLimits
+		expectedMoves int
+		expectedError error
+	}{
+		{
+			name:   "already won",
+			width:  2,
+			height: 1,
+			win:    LastColumnCondition{},
+			setupFunc: func(ds *DataStore) {
+				ds.State.Grid[0][0] = []Circle{}
+			},
+			limits:        DefaultSolverLimits,
+			expectedMoves: 0,
+		},
+		{
+			name:   "carry one circle across",
+			width:  2,
+			height: 1,
+			win:    LastColumnCondition{},
+			setupFunc: func(ds *DataStore) {
+				ds.State.Grid[1][0] = []Circle{}
+			},
+			limits:        DefaultSolverLimits,
+			expectedMoves: 3,
+		},
+		{
+			name:          "default layout",
+			width:         DefaultGridSize,
+			height:        DefaultGridSize,
+			win:           LastColumnCondition{},
+			setupFunc:     func(ds *DataStore) {},
+			limits:        DefaultSolverLimits,
+			expectedMoves: 38,
+		},
+		{
+			name:   "two reds cannot share a cell",
+			width:  2,
+			height: 1,
+			win:    TargetCellCondition{X: 1, Y: 0},
+			setupFunc: func(ds *DataStore) {
+				ds.State.Grid[0][0] = []Circle{Red}
+				ds.State.Grid[1][0] = []Circle{Red}
+			},
+			limits:        DefaultSolverLimits,
+			expectedError: ErrUnsolvable,
+		},
+		{
+			name:   "stack limit leaves no room on the target",
+			width:  2,
+			height: 1,
+			win:    TargetCellCondition{X: 1, Y: 0},
+			setupFunc: func(ds *DataStore) {
+				ds.State.Grid[0][0] = []Circle{Green}
+				ds.State.Grid[1][0] = []Circle{Green}
+				ds.State.StackLimit = 1
+			},
+			limits:        DefaultSolverLimits,
+			expectedError: ErrUnsolvable,
+		},
+		{
+			name:   "robots hand a circle over",
+			width:  3,
+			height: 1,
+			win:    LastColumnCondition{},
+			setupFunc: func(ds *DataStore) {
+				ds.State.Grid[1][0] = []Circle{}
+				ds.State.Grid[2][0] = []Circle{}
+				ds.State.Robots = append(ds.State.Robots, Robot{ID: "2", PositionX: 2})
+			},
+			limits:        DefaultSolverLimits,
+			expectedMoves: 8,
+		},
+		{
+			name:   "route around a second robot",
+			width:  3,
+			height: 2,
+			win:    LastColumnCondition{},
+			setupFunc: func(ds *DataStore) {
+				for x := range 3 {
+					ds.State.Grid[x] = [][]Circle{{}, {}}
+				}
+				ds.State.Grid[0][0] = []Circle{Red}
+				ds.State.Robots = append(ds.State.Robots, Robot{ID: "2", PositionX: 2})
+			},
+			limits:        DefaultSolverLimits,
+			expectedMoves: 5,
+		},
+		{
+			name:          "node limit reached",
+			width:         DefaultGridSize,
+			height:        DefaultGridSize,
+			win:           LastColumnCondition{},
+			setupFunc:     func(ds *DataStore) {},
+			limits:        SolverLimits{MaxNodes: 100, Timeout: DefaultSolverLimits.Timeout},
+			expectedError: ErrSolverLimit,
+		},
+		{
+			name:          "large board gets a smaller node budget",
+			width:         MaxGridSize,
+			height:        MaxGridSize,
+			win:           LastColumnCondition{},
+			setupFunc:     func(ds *DataStore) {},
+			limits:        SolverLimits{MaxNodes: 1_000_000, Timeout: time.Minute},
+			expectedError: ErrSolverLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDataStore(tt.width, tt.height)
+			tt.setupFunc(ds)
+
+			svc := NewService(ds, ClassicRule{}, tt.win)
+
+			commands, err := svc.Solve(tt.limits)
+
+			if tt.expectedError != nil {
+				if !errors.Is(err, tt.expectedError) {
+					t.Fatalf("expected error '%v', got '%v'", tt.expectedError, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(commands) != tt.expectedMoves {
+				t.Fatalf("expected %d moves, got %d", tt.expectedMoves, len(commands))
+			}
+
+			for i, cmd := range commands {
+				if _, err := svc.Execute(cmd); err != nil {
+					t.Fatalf("solution step %d (%v) failed: %v", i, cmd, err)
+				}
+			}
+
+			if !svc.HasWon() {
+				t.Fatalf("expected solution to win the game")
+			}
+		})
+	}
+}
+
+func TestService_SolveOneAtATime(t *testing.T) {
+	solverSlots <- struct{}{}
+	defer func() { <-solverSlots }()
+
+	svc := NewService(NewDataStore(DefaultGridSize, DefaultGridSize), ClassicRule{}, LastColumnCondition{})
+	if _, err := svc.Solve(SolverLimits{MaxNodes: 100, Timeout: 10 * time.Millisecond}); !errors.Is(err, ErrSolverLimit) {
+		t.Fatalf("expected a solve waiting past its timeout to give up, got %v", err)
+	}
+}
+
+func TestService_Hint(t *testing.T) {
+	tests := []struct {
+		name              string
+		setupFunc         func(*DataStore)
+		expectedCommand   CommandRequest
+		expectedRemaining int
+		expectedError     error
+	}{
+		{
+			name: "pick before carrying",
+			setupFunc: func(ds *DataStore) {
+				ds.State.Grid[1][0] = []Circle{}
+			},
+			expectedCommand:   CommandRequest{Action: PickUp},
+			expectedRemaining: 3,
+		},
+		{
+			name: "move while holding",
+			setupFunc: func(ds *DataStore) {
+				ds.State.Robots[0].Holding = []Circle{Red}
+				ds.State.Grid[0][0] = []Circle{}
+				ds.State.Grid[1][0] = []Circle{}
+			},
+			expectedCommand:   CommandRequest{Action: Move, Direction: Right},
+			expectedRemaining: 2,
+		},
+		{
+			name: "nothing left to do",
+			setupFunc: func(ds *DataStore) {
+				ds.State.Grid[0][0] = []Circle{}
+			},
+			expectedError: ErrAlreadyWon,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDataStore(2, 1)
+			tt.setupFunc(ds)
+
+			svc := NewService(ds, ClassicRule{}, LastColumnCondition{})
+
+			command, remaining, err := svc.Hint(DefaultSolverLimits)
+
+			if tt.expectedError != nil {
+				if !errors.Is(err, tt.expectedError) {
+					t.Fatalf("expected error '%v', got '%v'", tt.expectedError, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if command != tt.expectedCommand || remaining != tt.expectedRemaining {
+				t.Fatalf("expected %v with %d remaining, got %v with %d",
+					tt.expectedCommand, tt.expectedRemaining, command, remaining)
+			}
+		})
+	}
+}
+
+func TestService_SolveMovementModels(t *testing.T) {
+	tests := []struct {
+		movement      MovementModel
+		expectedMoves int
+	}{
+		{movement: FourNeighbour, expectedMoves: 6},
+		{movement: EightNeighbour, expectedMoves: 4},
+		{movement: Teleport, expectedMoves: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.movement), func(t *testing.T) {
+			board := defaultBoard(DefaultGridSize, DefaultGridSize, 1)
+			for x := range DefaultGridSize {
+				board.Grid[x] = [][]Circle{{}, {}, {}}
+			}
+			board.Grid[0][0] = []Circle{Red}
+
+			svc := NewService(newDataStore(board), ClassicRule{}, TargetCellCondition{X: 2, Y: 2})
+			svc.movement = tt.movement
+
+			commands, err := svc.Solve(DefaultSolverLimits)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(commands) != tt.expectedMoves {
+				t.Fatalf("expected %d moves, got %d: %+v", tt.expectedMoves, len(commands), commands)
+			}
+
+			for i, cmd := range commands {
+				if _, err := svc.Execute(cmd); err != nil {
+					t.Fatalf("solution step %d (%v) failed: %v", i, cmd, err)
+				}
+			}
+			if !svc.HasWon() {
+				t.Fatalf("expected solution to win the game")
+			}
+		})
+	}
+}
+
+func TestService_SolveDeadline(t *testing.T) {
+	svc := NewService(NewDataStore(MaxGridSize, MaxGridSize), ClassicRule{}, LastColumnCondition{})
+	svc.movement = Teleport
+
+	start := time.Now()
+	_, err := svc.Solve(SolverLimits{MaxNodes: 10_000_000, Timeout: 100 * time.Millisecond})
+	if !errors.Is(err, ErrSolverLimit) {
+		t.Fatalf("expected the solver to give up, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the solver to stop at its timeout, took %v", elapsed)
+	}
+}
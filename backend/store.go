@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GameRecord is everything needed to bring a game back after a restart,
+// including the commands that can still be undone and redone.
+// Optimal keeps the energy of the cheapest solution once it is known, so a
+// restored game does not search for it again.
+type GameRecord struct {
+	ID         string            `json:"id"`
+	Config     GameConfig        `json:"config"`
+	Initial    State             `json:"initial"`
+	State      State             `json:"state"`
+	History    []MovementHistory `json:"history"`
+	Sequence   int               `json:"sequence"`
+	Done       []operation       `json:"done,omitempty"`
+	Undone     []operation       `json:"undone,omitempty"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at,omitzero"`
+	Ranked     bool              `json:"ranked,omitempty"`
+	Optimal    *int              `json:"optimal,omitempty"`
+}
+
+// ArchivedGame is a played-out game kept in the completed-games log when its
+// session is reset. Lost marks timed games that ran out of time.
+type ArchivedGame struct {
+	GameID     string            `json:"game_id"`
+	LayoutID   string            `json:"layout_id,omitempty"`
+	Initial    State             `json:"initial"`
+	Final      State             `json:"final"`
+	History    []MovementHistory `json:"history"`
+	Won        bool              `json:"won"`
+	Lost       bool              `json:"lost,omitempty"`
+	ArchivedAt time.Time         `json:"archived_at"`
+}
+
+type Store interface {
+	SaveGame(record GameRecord) error
+	LoadGames() ([]GameRecord, error)
+	DeleteGame(id string) error
+	ArchiveGame(game ArchivedGame) error
+	LoadArchive(gameID string) ([]ArchivedGame, error)
+	AddToLeaderboard(entry LeaderboardEntry) error
+	LoadLeaderboard(layoutID string) ([]LeaderboardEntry, error)
+}
+
+func NewStore(kind, dir string) (Store, error) {
+	switch kind {
+	case "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		return NewFileStore(dir)
+	}
+	return nil, fmt.Errorf("unknown store %q", kind)
+}
+
+type MemoryStore struct {
+	mu          sync.Mutex
+	games       map[string]GameRecord
+	archive     []ArchivedGame
+	leaderboard []LeaderboardEntry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{games: map[string]GameRecord{}}
+}
+
+func (m *MemoryStore) SaveGame(record GameRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.games[record.ID] = record
+	return nil
+}
+
+func (m *MemoryStore) LoadGames() ([]GameRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Collect(maps.Values(m.games)), nil
+}
+
+func (m *MemoryStore) DeleteGame(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.games, id)
+	return nil
+}
+
+func (m *MemoryStore) ArchiveGame(game ArchivedGame) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.archive = append(m.archive, game)
+	return nil
+}
+
+func (m *MemoryStore) LoadArchive(gameID string) ([]ArchivedGame, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return archivedFor(m.archive, gameID), nil
+}
+
+func (m *MemoryStore) AddToLeaderboard(entry LeaderboardEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.leaderboard = append(m.leaderboard, entry)
+	return nil
+}
+
+func (m *MemoryStore) LoadLeaderboard(layoutID string) ([]LeaderboardEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return leaderboardFor(m.leaderboard, layoutID), nil
+}
+
+// archivedFor filters games down to those of one game ID, or returns them
+// all when gameID is empty.
+func archivedFor(games []ArchivedGame, gameID string) []ArchivedGame {
+	matched := []ArchivedGame{}
+	for _, game := range games {
+		if gameID == "" || game.GameID == gameID {
+			matched = append(matched, game)
+		}
+	}
+	return matched
+}
+
+// FileStore keeps one JSON file per game in a directory, plus the
+// completed-games log and the leaderboard as one JSON object per line in
+// archiveFile and leaderboardFile.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+const (
+	archiveFile     = "archive.jsonl"
+	leaderboardFile = "leaderboard.jsonl"
+)
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) path(id string) string {
+	return filepath.Join(f.dir, id+".json")
+}
+
+// SaveGame writes to a temporary file first so a crash never leaves a
+// half-written game behind.
+func (f *FileStore) SaveGame(record GameRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(f.dir, record.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(record.ID))
+}
+
+func (f *FileStore) LoadGames() ([]GameRecord, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []GameRecord
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(f.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var record GameRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			log.Printf("skipping unreadable game file %s: %v", entry.Name(), err)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (f *FileStore) DeleteGame(id string) error {
+	err := os.Remove(f.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (f *FileStore) ArchiveGame(game ArchivedGame) error {
+	return f.appendLine(archiveFile, game)
+}
+
+func (f *FileStore) LoadArchive(gameID string) ([]ArchivedGame, error) {
+	games, err := readLines[ArchivedGame](f, archiveFile)
+	if err != nil {
+		return nil, err
+	}
+	return archivedFor(games, gameID), nil
+}
+
+func (f *FileStore) AddToLeaderboard(entry LeaderboardEntry) error {
+	return f.appendLine(leaderboardFile, entry)
+}
+
+func (f *FileStore) LoadLeaderboard(layoutID string) ([]LeaderboardEntry, error) {
+	entries, err := readLines[LeaderboardEntry](f, leaderboardFile)
+	if err != nil {
+		return nil, err
+	}
+	return leaderboardFor(entries, layoutID), nil
+}
+
+// appendLine adds v to the end of the named log file as one line of JSON.
+func (f *FileStore) appendLine(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(filepath.Join(f.dir, name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readLines reads back every line appendLine wrote to the named log file,
+// skipping any it cannot parse.
+func readLines[T any](f *FileStore, name string) ([]T, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.Open(filepath.Join(f.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var values []T
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var value T
+		if err := json.Unmarshal(scanner.Bytes(), &value); err != nil {
+			log.Printf("skipping unreadable %s entry: %v", name, err)
+			continue
+		}
+		values = append(values, value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	held := Blue
+	record := GameRecord{
+		ID:     "GAME1",
+		Config: testDefaults(),
+		State: State{
+			Robots: []Robot{{ID: "1", PositionX: 1, PositionY: 2, Holding: []Circle{held}}},
+			Width:  2,
+			Height: 3,
+			Grid:   [][][]Circle{{{Red}, {}, {Green}}, {{}, {Blue, Red}, {}}},
+		},
+		History:  []MovementHistory{{Sequence: 1, Action: Move, Direction: Down, Success: true}},
+		Sequence: 1,
+	}
+
+	tests := []struct {
+		name         string
+		setupFunc    func(*testing.T, *FileStore)
+		validateFunc func(*testing.T, []GameRecord)
+	}{
+		{
+			name: "saved game is loaded back",
+			setupFunc: func(t *testing.T, fs *FileStore) {
+				if err := fs.SaveGame(record); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			},
+			validateFunc: func(t *testing.T, records []GameRecord) {
+				if len(records) != 1 {
+					t.Fatalf("expected 1 record, got %d", len(records))
+				}
+				got := records[0]
+				if got.ID != record.ID || got.State.Robots[0].PositionY != 2 || got.State.Robots[0].top() != Blue {
+					t.Fatalf("unexpected record %+v", got)
+				}
+				if len(got.State.Grid[1][1]) != 2 || got.State.Grid[1][1][1] != Red {
+					t.Fatalf("expected stack [blue red] at (1,1), got %v", got.State.Grid[1][1])
+				}
+				if len(got.History) != 1 || got.History[0].Direction != Down {
+					t.Fatalf("unexpected history %v", got.History)
+				}
+			},
+		},
+		{
+			name: "saving again replaces the game",
+			setupFunc: func(t *testing.T, fs *FileStore) {
+				fs.SaveGame(record)
+				updated := record
+				updated.Sequence = 5
+				fs.SaveGame(updated)
+			},
+			validateFunc: func(t *testing.T, records []GameRecord) {
+				if len(records) != 1 || records[0].Sequence != 5 {
+					t.Fatalf("expected one record with sequence 5, got %+v", records)
+				}
+			},
+		},
+		{
+			name: "deleted game is gone",
+			setupFunc: func(t *testing.T, fs *FileStore) {
+				fs.SaveGame(record)
+				if err := fs.DeleteGame(record.ID); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			},
+			validateFunc: func(t *testing.T, records []GameRecord) {
+				if len(records) != 0 {
+					t.Fatalf("expected no records, got %d", len(records))
+				}
+			},
+		},
+		{
+			name: "unreadable files are skipped",
+			setupFunc: func(t *testing.T, fs *FileStore) {
+				fs.SaveGame(record)
+				os.WriteFile(filepath.Join(fs.dir, "broken.json"), []byte("{"), 0o644)
+			},
+			validateFunc: func(t *testing.T, records []GameRecord) {
+				if len(records) != 1 {
+					t.Fatalf("expected 1 record, got %d", len(records))
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, err := NewFileStore(t.TempDir())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			tt.setupFunc(t, fs)
+
+			records, err := fs.LoadGames()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			tt.validateFunc(t, records)
+		})
+	}
+}
+
+func TestFileStore_Archive(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, game := range []ArchivedGame{
+		{GameID: "GAME1", LayoutID: "corner", Won: true},
+		{GameID: "GAME2"},
+		{GameID: "GAME1", History: []MovementHistory{{Sequence: 1, Action: PickUp, Success: true}}},
+	} {
+		if err := fs.ArchiveGame(game); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		gameID   string
+		expected int
+	}{
+		{gameID: "GAME1", expected: 2},
+		{gameID: "GAME2", expected: 1},
+		{gameID: "GAME3", expected: 0},
+		{gameID: "", expected: 3},
+	}
+
+	for _, tt := range tests {
+		games, err := fs.LoadArchive(tt.gameID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(games) != tt.expected {
+			t.Errorf("LoadArchive(%q) returned %d games, expected %d", tt.gameID, len(games), tt.expected)
+		}
+	}
+
+	records, err := fs.LoadGames()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("expected the archive not to be loaded as a game, got %d records", len(records))
+	}
+}
+
+func TestFileStore_Leaderboard(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, entry := range []LeaderboardEntry{
+		{GameID: "GAME1", LayoutID: "corner"},
+		{GameID: "GAME2", LayoutID: "corner"},
+		{GameID: "GAME3"},
+	} {
+		if err := fs.AddToLeaderboard(entry); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		layoutID string
+		expected int
+	}{
+		{layoutID: "corner", expected: 2},
+		{layoutID: "warehouse", expected: 0},
+		{layoutID: "", expected: 3},
+	}
+
+	for _, tt := range tests {
+		entries, err := fs.LoadLeaderboard(tt.layoutID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(entries) != tt.expected {
+			t.Errorf("LoadLeaderboard(%q) returned %d entries, expected %d", tt.layoutID, len(entries), tt.expected)
+		}
+	}
+}
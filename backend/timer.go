@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrTimeUp is returned for commands sent to a timed game after its deadline.
+var ErrTimeUp = errors.New("time is up: the game is lost")
+
+// Clock is the timing of a game at one moment. Remaining is only meaningful
+// for timed games; Lost is set once a timed game runs out of time unwon.
+type Clock struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Elapsed    time.Duration
+	TimeLimit  time.Duration
+	Remaining  time.Duration
+	Lost       bool
+}
+
+func (s *Service) Clock(now time.Time) Clock {
+	s.storage.Mu.Lock()
+	defer s.storage.Mu.Unlock()
+	return s.clock(now)
+}
+
+// clock works out the timing of the game at now. The caller must hold the
+// storage lock.
+func (s *Service) clock(now time.Time) Clock {
+	clock := Clock{
+		StartedAt:  s.storage.StartedAt,
+		FinishedAt: s.storage.FinishedAt,
+		TimeLimit:  s.timeLimit,
+	}
+	if !clock.FinishedAt.IsZero() {
+		now = clock.FinishedAt
+	}
+	clock.Elapsed = now.Sub(clock.StartedAt)
+
+	if s.timeLimit > 0 {
+		clock.Remaining = max(s.timeLimit-clock.Elapsed, 0)
+		clock.Lost = clock.FinishedAt.IsZero() && clock.Remaining == 0
+	}
+	return clock
+}
+
+// timeUp reports whether the game is timed and has run out of time without
+// being won. The caller must hold the storage lock.
+func (s *Service) timeUp() bool {
+	return s.clock(time.Now()).Lost
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+const DefaultWinCondition = "last_column"
+
+type WinCondition interface {
+	Name() string
+	Description() string
+	Met(state *State) bool
+}
+
+// estimator is implemented by win conditions that can give the solver a lower
+// bound on the number of commands still needed from a state.
+type estimator interface {
+	Estimate(state *State, m motion) int
+}
+
+// roomChecker is implemented by win conditions that can tell from the stack
+// limits alone that their goal cannot hold every circle.
+type roomChecker interface {
+	HasRoom(state *State) bool
+}
+
+// checkRoom fails when win is a roomChecker without room on state.
+func checkRoom(win WinCondition, state *State) error {
+	if r, ok := win.(roomChecker); ok && !r.HasRoom(state) {
+		return fmt.Errorf("the stack limits leave no room to meet the %s win condition", win.Name())
+	}
+	return nil
+}
+
+// motion is what estimates need to know about how far robots travel per move
+// and how much they carry.
+type motion struct {
+	capacity int
+	movement MovementModel
+}
+
+// carryEstimate counts, for every circle, the pick, drop and loaded moves
+// needed to bring it to a cell whose distance is given by dist. Once robots
+// can carry several circles a single pick, drop or move may serve many of
+// them, so only one pick per cell still to clear, one drop and the moves for
+// the farthest circle are counted.
+func carryEstimate(state *State, m motion, dist func(x, y int) int) int {
+	if m.capacity > 1 {
+		picks, farthest := 0, -1
+		for _, robot := range state.Robots {
+			if len(robot.Holding) > 0 {
+				farthest = max(farthest, dist(robot.PositionX, robot.PositionY))
+			}
+		}
+		for x := range state.Width {
+			for y := range state.Height {
+				if d := dist(x, y); d > 0 && len(state.Grid[x][y]) > 0 {
+					picks++
+					farthest = max(farthest, d)
+				}
+			}
+		}
+		if farthest < 0 {
+			return 0
+		}
+		return picks + farthest + 1
+	}
+
+	total := 0
+	for _, robot := range state.Robots {
+		if len(robot.Holding) > 0 {
+			total += 1 + dist(robot.PositionX, robot.PositionY)
+		}
+	}
+
+	for x := range state.Width {
+		for y := range state.Height {
+			if d := dist(x, y); d > 0 {
+				total += len(state.Grid[x][y]) * (2 + d)
+			}
+		}
+	}
+	return total
+}
+
+type LastColumnCondition struct{}
+
+func (LastColumnCondition) Name() string { return "last_column" }
+
+func (LastColumnCondition) Description() string {
+	return "Move every circle into the last column of the grid"
+}
+
+func (LastColumnCondition) Met(state *State) bool {
+	if state.carrying() {
+		return false
+	}
+
+	for x := range state.Width - 1 {
+		for y := range state.Height {
+			if len(state.Grid[x][y]) > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// HasRoom checks the last column, walls excluded, can hold every circle.
+func (LastColumnCondition) HasRoom(state *State) bool {
+	room, x := 0, state.Width-1
+	for y := range state.Height {
+		if state.cell(x, y) == Wall {
+			continue
+		}
+		limit := state.stackLimit(x, y)
+		if limit == 0 {
+			return true
+		}
+		room += limit
+	}
+	return room >= state.circles()
+}
+
+func (LastColumnCondition) Estimate(state *State, m motion) int {
+	return carryEstimate(state, m, func(x, y int) int { return m.movement.steps(state.Width-1-x, 0) })
+}
+
+type TargetCellCondition struct {
+	X int
+	Y int
+}
+
+func (TargetCellCondition) Name() string { return "target_cell" }
+
+func (c TargetCellCondition) Description() string {
+	return fmt.Sprintf("Stack every circle on cell (%d,%d)", c.X, c.Y)
+}
+
+func (c TargetCellCondition) Met(state *State) bool {
+	if state.carrying() {
+		return false
+	}
+
+	for x := range state.Width {
+		for y := range state.Height {
+			if (x != c.X || y != c.Y) && len(state.Grid[x][y]) > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c TargetCellCondition) HasRoom(state *State) bool {
+	limit := state.stackLimit(c.X, c.Y)
+	return limit == 0 || limit >= state.circles()
+}
+
+func (c TargetCellCondition) Estimate(state *State, m motion) int {
+	return carryEstimate(state, m, func(x, y int) int { return m.movement.steps(x-c.X, y-c.Y) })
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// SortedColumnsCondition is met once every column holds circles of a single colour.
+type SortedColumnsCondition struct{}
+
+func (SortedColumnsCondition) Name() string { return "sorted_columns" }
+
+func (SortedColumnsCondition) Description() string {
+	return "Sort the circles so that every column holds a single colour"
+}
+
+func (SortedColumnsCondition) Met(state *State) bool {
+	if state.carrying() {
+		return false
+	}
+
+	for x := range state.Width {
+		var colour Circle
+		for y := range state.Height {
+			for _, circle := range state.Grid[x][y] {
+				if colour == "" {
+					colour = circle
+				}
+				if circle != colour {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+type TargetGridCondition struct {
+	Grid [][][]Circle
+}
+
+func (TargetGridCondition) Name() string { return "target_grid" }
+
+func (TargetGridCondition) Description() string {
+	return "Rearrange the circles to match the target grid exactly"
+}
+
+func (c TargetGridCondition) Met(state *State) bool {
+	if state.carrying() || len(c.Grid) != state.Width {
+		return false
+	}
+
+	for x := range state.Width {
+		if len(c.Grid[x]) != state.Height {
+			return false
+		}
+		for y := range state.Height {
+			if !slices.Equal(c.Grid[x][y], state.Grid[x][y]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c TargetGridCondition) HasRoom(state *State) bool {
+	for x := range min(state.Width, len(c.Grid)) {
+		for y := range min(state.Height, len(c.Grid[x])) {
+			if limit := state.stackLimit(x, y); limit > 0 && len(c.Grid[x][y]) > limit {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+type WinConditionConfig struct {
+	Name    string       `json:"name"`
+	TargetX *int         `json:"target_x,omitempty"`
+	TargetY *int         `json:"target_y,omitempty"`
+	Grid    [][][]Circle `json:"grid,omitempty"`
+}
+
+var winConditions = map[string]func(cfg WinConditionConfig, width, height int) (WinCondition, error){
+	"last_column": func(WinConditionConfig, int, int) (WinCondition, error) {
+		return LastColumnCondition{}, nil
+	},
+	"target_cell": func(cfg WinConditionConfig, width, height int) (WinCondition, error) {
+		cond := TargetCellCondition{X: width - 1, Y: height - 1}
+		if cfg.TargetX != nil {
+			cond.X = *cfg.TargetX
+		}
+		if cfg.TargetY != nil {
+			cond.Y = *cfg.TargetY
+		}
+		if cond.X < 0 || cond.X >= width || cond.Y < 0 || cond.Y >= height {
+			return nil, fmt.Errorf("target cell (%d,%d) is outside the %dx%d grid", cond.X, cond.Y, width, height)
+		}
+		return cond, nil
+	},
+	"sorted_columns": func(WinConditionConfig, int, int) (WinCondition, error) {
+		return SortedColumnsCondition{}, nil
+	},
+	"target_grid": func(cfg WinConditionConfig, width, height int) (WinCondition, error) {
+		if len(cfg.Grid) != width {
+			return nil, fmt.Errorf("target grid must have %d columns, got %d", width, len(cfg.Grid))
+		}
+		for x := range cfg.Grid {
+			if len(cfg.Grid[x]) != height {
+				return nil, fmt.Errorf("target grid column %d must have %d cells, got %d", x, height, len(cfg.Grid[x]))
+			}
+		}
+		return TargetGridCondition{Grid: cfg.Grid}, nil
+	},
+}
+
+// winConditionAliases maps names that saved games may still use to the win
+// conditions they now name. last_row always checked the last column.
+var winConditionAliases = map[string]string{"last_row": "last_column"}
+
+func NewWinCondition(cfg WinConditionConfig, width, height int) (WinCondition, error) {
+	if name, ok := winConditionAliases[cfg.Name]; ok {
+		cfg.Name = name
+	}
+	factory, ok := winConditions[cfg.Name]
+	if !ok {
+		return nil, fmt.Errorf("unknown win condition %q", cfg.Name)
+	}
+	return factory(cfg, width, height)
+}
+
+func WinConditionNames() []string {
+	return slices.Sorted(maps.Keys(winConditions))
+}
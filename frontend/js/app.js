@@ -1,14 +1,35 @@
 const GRID = document.getElementById("grid");
 const EXPORT_BTN = document.getElementById("export-btn");
+const UNDO_BTN = document.getElementById("undo-btn");
+const REDO_BTN = document.getElementById("redo-btn");
+const HINT_BTN = document.getElementById("hint-btn");
+const RESET_BTN = document.getElementById("reset-btn");
 const MESSAGE = document.getElementById("message");
 const HOLDING = document.getElementById("holding");
+const GOAL = document.getElementById("goal");
+const ENERGY = document.getElementById("energy");
+const TIMER = document.getElementById("timer");
+const COUNT = document.getElementById("count");
+const DIAGONALS = document.getElementById("diagonal-controls");
 
 let _messageTimer = null;
+let _countdown = null;
 const BASE_URL = "http://localhost:8080";
+const SESSION_KEY = "robot-session-id";
+let sessionId = sessionStorage.getItem(SESSION_KEY);
+let selectedRobot = null;
+let lastState = null;
+
 const END_POINTS = {
-    state: `${BASE_URL}/state`,
-    command: `${BASE_URL}/command`,
-    export: `${BASE_URL}/export`
+    sessions: `${BASE_URL}/sessions`,
+    state: () => `${BASE_URL}/sessions/${sessionId}/state`,
+    command: () => `${BASE_URL}/sessions/${sessionId}/command`,
+    export: () => `${BASE_URL}/sessions/${sessionId}/export`,
+    undo: () => `${BASE_URL}/sessions/${sessionId}/undo`,
+    redo: () => `${BASE_URL}/sessions/${sessionId}/redo`,
+    hint: () => `${BASE_URL}/sessions/${sessionId}/hint`,
+    reset: () => `${BASE_URL}/sessions/${sessionId}/reset`,
+    events: () => `${BASE_URL}/sessions/${sessionId}/events`
 };
 
 function showErrorMessage(text) {
@@ -27,23 +48,91 @@ function showErrorMessage(text) {
     }, 4000);
 }
 
-function showWinMessage() {
+function showWinMessage(score) {
     if (!MESSAGE) return;
     MESSAGE.textContent = 'Task successfully completed!';
+    if (score && score.optimal > 0) {
+        MESSAGE.textContent += ` Score: ${score.points} (${Math.round(score.efficiency * 100)}% efficient)`;
+    }
     MESSAGE.className = `message show success`;
 }
 
-async function fetchInitialState() {
-    const res = await fetch(END_POINTS.state);
+async function createSession() {
+    const res = await fetch(END_POINTS.sessions, { method: "POST" });
     const data = await res.json();
-    render(data);
+    sessionId = data.id;
+    sessionStorage.setItem(SESSION_KEY, sessionId);
+    return data.state;
+}
+
+function showHintMessage(text) {
+    if (!MESSAGE) return;
+    clearTimeout(_messageTimer);
+    MESSAGE.textContent = text;
+    MESSAGE.className = `message show hint`;
+    _messageTimer = setTimeout(() => {
+        MESSAGE.className = 'message';
+        MESSAGE.textContent = '';
+    }, 4000);
+}
+
+function describeCommand(command) {
+    const robot = command.robot_id ? `robot ${command.robot_id}: ` : "";
+    switch (command.action) {
+        case "move": return `${robot}move ${command.direction}`;
+        case "move_to": return `${robot}move to (${command.x},${command.y})`;
+        case "pick_up": return `${robot}pick${command.count ? ` ${command.count}` : ""}`;
+        case "drop": return `${robot}drop${command.count ? ` ${command.count}` : ""}`;
+        default: return command.action;
+    }
 }
 
-async function sendCommand(action, direction = null) {
-    const res = await fetch(END_POINTS.command, {
+async function fetchHint() {
+    const res = await fetch(END_POINTS.hint());
+    const data = await res.json();
+
+    if (!res.ok) {
+        showErrorMessage(`No hint available: ${data.error}`);
+        return;
+    }
+
+    showHintMessage(`Hint: ${describeCommand(data.command)} (${data.moves_remaining} moves to go)`);
+}
+
+async function fetchInitialState() {
+    if (sessionId) {
+        const res = await fetch(END_POINTS.state());
+        if (res.ok) {
+            render(await res.json());
+            subscribe();
+            return;
+        }
+    }
+    render(await createSession());
+    subscribe();
+}
+
+function subscribe() {
+    const source = new EventSource(END_POINTS.events());
+    source.addEventListener("state", (e) => {
+        const data = JSON.parse(e.data);
+        render(data.state);
+    });
+}
+
+async function sendCommand(action, direction = null, target = null) {
+    const count = action === "pick_up" || action === "drop" ? parseInt(COUNT.value, 10) : 0;
+    const res = await fetch(END_POINTS.command(), {
         method: "POST",
         headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ action, direction: direction || undefined })
+        body: JSON.stringify({
+            robot_id: selectedRobot || undefined,
+            action,
+            direction: direction || undefined,
+            count: count > 1 ? count : undefined,
+            x: target ? target.x : undefined,
+            y: target ? target.y : undefined
+        })
     });
 
     if (!res.ok) {
@@ -56,29 +145,103 @@ async function sendCommand(action, direction = null) {
     await render(data);
 }
 
+async function stepHistory(url) {
+    const res = await fetch(url, { method: "POST" });
+
+    if (!res.ok) {
+        const msg = await res.json();
+        showErrorMessage(msg.error);
+        return;
+    }
+
+    const data = await res.json();
+    await render(data);
+}
+
+// circleElement draws a circle in the colour its game's palette registers,
+// falling back to the stylesheet for the built-in colours.
+function circleElement(name, palette) {
+    const div = document.createElement("div");
+    div.className = `circle ${name}`;
+    div.title = name;
+    const type = (palette || []).find(t => t.name === name);
+    if (type) div.style.background = type.colour;
+    return div;
+}
+
+// showTimer counts a timed game down locally from the remaining time the
+// server last reported.
+function showTimer(state) {
+    if (!TIMER) return;
+    clearInterval(_countdown);
+
+    if (state.lost) {
+        TIMER.textContent = "Time's up, the game is lost";
+        return;
+    }
+    if (state.remaining_ms == null) {
+        TIMER.textContent = '';
+        return;
+    }
+
+    const deadline = Date.now() + state.remaining_ms;
+    const tick = () => {
+        const left = Math.max(deadline - Date.now(), 0);
+        TIMER.textContent = `Time left: ${Math.ceil(left / 1000)}s`;
+        if (left === 0) {
+            clearInterval(_countdown);
+            TIMER.textContent = "Time's up, the game is lost";
+        }
+    };
+    tick();
+    if (!state.finished_at) _countdown = setInterval(tick, 250);
+}
+
 async function render(state) {
     GRID.innerHTML = "";
+    lastState = state;
 
     const gridData = state.grid;
-    const robotX = state.position_x;
-    const robotY = state.position_y;
+    const robots = state.robots || [];
+    if (!robots.some(r => r.id === selectedRobot)) {
+        selectedRobot = robots.length > 0 ? robots[0].id : null;
+    }
+    const selected = robots.find(r => r.id === selectedRobot);
+
+    GRID.style.gridTemplateColumns = `repeat(${state.width}, 100px)`;
+    GRID.style.gridTemplateRows = `repeat(${state.height}, 100px)`;
 
-    for (let y = 0; y < gridData.length; y++) {
-        for (let x = 0; x < gridData[y].length; x++) {
+    for (let y = 0; y < state.height; y++) {
+        for (let x = 0; x < state.width; x++) {
             const cell = document.createElement("div");
             cell.className = "cell";
+            if (state.cells && state.cells[x][y]) cell.classList.add(state.cells[x][y]);
+            cell.dataset.x = x;
+            cell.dataset.y = y;
 
             const stack = gridData[x][y];
 
             stack.forEach(color => {
-                const div = document.createElement("div");
-                div.className = `circle ${color}`;
-                cell.appendChild(div);
+                cell.appendChild(circleElement(color, state.palette));
             });
 
-            if (robotX === x && robotY === y) {
+            const limit = state.stack_limits ? state.stack_limits[x][y] : 0;
+            if (limit > 0) {
+                const label = document.createElement("span");
+                label.className = "limit";
+                label.textContent = `${stack.length}/${limit}`;
+                cell.appendChild(label);
+            }
+
+            const robotHere = robots.find(r => r.position_x === x && r.position_y === y);
+            if (robotHere) {
                 const robot = document.createElement("div");
                 robot.className = "robot";
+                robot.dataset.robotId = robotHere.id;
+                if (robots.length > 1) {
+                    robot.textContent = robotHere.id;
+                    if (robotHere.id === selectedRobot) robot.classList.add("selected");
+                }
                 cell.appendChild(robot);
             }
 
@@ -86,21 +249,40 @@ async function render(state) {
         }
     }
 
+    const holding = selected ? selected.holding : state.holding;
     if (HOLDING) {
         HOLDING.innerHTML = '';
-        if (state.holding) {
+        if (holding && holding.length > 0) {
             HOLDING.classList.remove('empty');
-            const dot = document.createElement('div');
-            dot.className = `circle ${state.holding}`;
-            HOLDING.appendChild(dot);
+            holding.forEach(color => {
+                HOLDING.appendChild(circleElement(color, state.palette));
+            });
         } else {
             HOLDING.classList.add('empty');
             HOLDING.textContent = 'Empty';
         }
     }
 
+    if (GOAL) {
+        GOAL.textContent = state.goal || '';
+    }
+
+    if (ENERGY) {
+        ENERGY.textContent = state.energy_budget
+            ? `Energy: ${state.energy_used} / ${state.energy_budget}`
+            : `Energy used: ${state.energy_used}`;
+    }
+
+    showTimer(state);
+
+    if (DIAGONALS) {
+        DIAGONALS.classList.toggle("hidden", state.movement !== "eight_neighbour");
+    }
+
     if (state.won) {
-        showWinMessage();
+        showWinMessage(state.score);
+    } else if (MESSAGE && MESSAGE.classList.contains('success')) {
+        showErrorMessage(null);
     }
 }
 
@@ -115,8 +297,37 @@ document.addEventListener("click", async (e) => {
     await sendCommand(action, direction);
 });
 
+GRID.addEventListener("click", async (e) => {
+    const id = e.target.dataset.robotId;
+    if (id && lastState) {
+        selectedRobot = id;
+        render(lastState);
+        return;
+    }
+
+    const cell = e.target.closest(".cell");
+    if (!cell) return;
+    await sendCommand("move_to", null, { x: Number(cell.dataset.x), y: Number(cell.dataset.y) });
+});
+
+UNDO_BTN.addEventListener("click", async () => {
+    await stepHistory(END_POINTS.undo());
+});
+
+REDO_BTN.addEventListener("click", async () => {
+    await stepHistory(END_POINTS.redo());
+});
+
+RESET_BTN.addEventListener("click", async () => {
+    await stepHistory(END_POINTS.reset());
+});
+
+HINT_BTN.addEventListener("click", async () => {
+    await fetchHint();
+});
+
 EXPORT_BTN.addEventListener("click", async () => {
-    window.location.href = END_POINTS.export;
+    window.location.href = END_POINTS.export();
 });
 
 fetchInitialState();